@@ -0,0 +1,193 @@
+package protobuf
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// AnyTypeURLPrefix prefixes the names of registered types
+// to form the type URLs of Any messages.
+const AnyTypeURLPrefix = "type.googleapis.com/"
+
+const anyProto = "google/protobuf/any.proto"
+
+// Any is the google.protobuf.Any well-known type, which holds
+// an encoded message along with a URL identifying its type.
+// Unlike a GeneratorID, the URL is meaningful to other
+// protobuf implementations.
+//
+// Pack and Unpack convert between registered types and Any values.
+// With the AnyInterfaces options, interface fields are sent
+// as Any messages as well.
+type Any struct {
+	TypeURL string `protobuf:"1,type_url"`
+	Value   []byte `protobuf:"2"`
+}
+
+// Proto3 implements Proto3Message, as Any is a proto3 message.
+func (*Any) Proto3() bool {
+	return true
+}
+
+var anyType = reflect.TypeOf(Any{})
+
+// typeURL returns the type URL of v, whose type must have been
+// registered under a name, by RegisterName, RegisterDerived
+// or RegisterType.
+func (r *Registry) typeURL(v interface{}) (string, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	id, ok := r.ids[reflect.TypeOf(v)]
+	if !ok || r.generators[id].name == "" {
+		return "", fmt.Errorf("%T is not registered by name", v)
+	}
+	return AnyTypeURLPrefix + r.generators[id].name, nil
+}
+
+// lookupURL returns the generator of the type named by url,
+// or nil if there is none. The name is the part of url after its host,
+// which may itself contain "/", as names from RegisterDerived do.
+// URLs with a longer path, as other implementations may write,
+// also match the name in their last segment.
+func (r *Registry) lookupURL(url string) InterfaceGeneratorFunc {
+	i := strings.Index(url, "/")
+	if i < 0 {
+		return nil
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, name := range []string{url[i+1:], url[strings.LastIndex(url, "/")+1:]} {
+		if e := r.generators[NameGeneratorID(name)]; name != "" && e.name == name {
+			return e.gen
+		}
+	}
+	return nil
+}
+
+// Pack encodes v, a value of a type registered in r by name,
+// into an Any.
+func (r *Registry) Pack(v interface{}) (*Any, error) {
+	en := encoder{
+		opts: &EncodeOptions{Registry: r},
+		path: &fieldPath{root: "Any"},
+	}
+	return en.pack(v)
+}
+
+// Unpack decodes a into a new value of the type its URL names,
+// which must have been registered in r by name.
+func (r *Registry) Unpack(a *Any) (interface{}, error) {
+	de := decoder{opts: DecodeOptions{Registry: r}}
+	de.path.root = "Any"
+	var v interface{}
+	if err := de.unpack(reflect.ValueOf(&v).Elem(), a.TypeURL, a.Value, 0); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Pack is like Registry.Pack, using DefaultRegistry.
+func Pack(v interface{}) (*Any, error) {
+	return DefaultRegistry.Pack(v)
+}
+
+// Unpack is like Registry.Unpack, using DefaultRegistry.
+func Unpack(a *Any) (interface{}, error) {
+	return DefaultRegistry.Unpack(a)
+}
+
+// pack encodes v as an Any. The value is the encoding of v
+// as a message, or its MarshalBinary output.
+func (en *encoder) pack(v interface{}) (*Any, error) {
+	url, err := en.registry().typeURL(v)
+	if err != nil {
+		return nil, err
+	}
+	if enc, ok := v.(encoding.BinaryMarshaler); ok {
+		b, err := enc.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		return &Any{TypeURL: url, Value: b}, nil
+	}
+	sval := reflect.Indirect(reflect.ValueOf(v))
+	if sval.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot pack %T, which is not a message", v)
+	}
+	emb := en.nested()
+	if err := emb.message(settable(sval)); err != nil {
+		return nil, err
+	}
+	return &Any{TypeURL: url, Value: emb.Bytes()}, nil
+}
+
+// anyValue writes the non-nil interface value val with key as an Any.
+func (en *encoder) anyValue(key uint64, val reflect.Value) error {
+	a, err := en.pack(val.Interface())
+	if err != nil {
+		return err
+	}
+	return en.value(key, reflect.ValueOf(a).Elem(), nil)
+}
+
+// anyValue decodes the Any message vb into the interface value val,
+// replacing its value by one of the type the Any names.
+// The offset off locates vb within the input passed to Decode.
+func (de *decoder) anyValue(val reflect.Value, vb []byte, off int) error {
+	var url string
+	var value []byte
+	valueOff := off
+	end := off + len(vb)
+	for len(vb) > 0 {
+		key, n := binary.Uvarint(vb)
+		if n <= 0 {
+			return errors.New("bad protobuf field key")
+		}
+		vb = vb[n:]
+		wiretype := int(key & 7)
+		if wiretype == 2 && (key>>3 == 1 || key>>3 == 2) {
+			content, rem, err := lengthDelimited(vb)
+			if err != nil {
+				return err
+			}
+			if key>>3 == 1 {
+				url = string(content)
+			} else {
+				value = content
+				valueOff = end - len(rem) - len(content)
+			}
+			vb = rem
+			continue
+		}
+		// Skip unknown fields.
+		var skip decoder
+		rem, err := skip.value(wiretype, vb, end-len(vb), reflect.Value{})
+		if err != nil {
+			return err
+		}
+		vb = rem
+	}
+	return de.unpack(val, url, value, valueOff)
+}
+
+// unpack sets the interface value val to the value of the type named
+// by url, decoded from the message buf at offset off.
+func (de *decoder) unpack(val reflect.Value, url string, buf []byte, off int) error {
+	g := de.registry().lookupURL(url)
+	if g == nil {
+		return fmt.Errorf("unknown Any type URL %q", url)
+	}
+	v := g()
+	if !reflect.TypeOf(v).AssignableTo(val.Type()) {
+		return fmt.Errorf("Any type %T does not implement %s", v, val.Type())
+	}
+	val.Set(reflect.ValueOf(v))
+	if dec, ok := v.(encoding.BinaryUnmarshaler); ok {
+		return dec.UnmarshalBinary(buf)
+	}
+	return de.decodeInterface(val, buf, off)
+}
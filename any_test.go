@@ -0,0 +1,132 @@
+package protobuf
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func anyTestRegistry(t *testing.T) *Registry {
+	r := NewRegistry()
+	_, err := r.RegisterType("example.Square", square{})
+	require.NoError(t, err)
+	_, err = r.RegisterType("example.Rect", &rect{})
+	require.NoError(t, err)
+	_, err = r.RegisterName("example.D", func() interface{} { return &testStructD{} })
+	require.NoError(t, err)
+	require.NoError(t, r.Register(func() interface{} { return &testStructB{} }))
+	return r
+}
+
+func TestPackUnpack(t *testing.T) {
+	r := anyTestRegistry(t)
+
+	a, err := r.Pack(square{3})
+	require.NoError(t, err)
+	assert.Equal(t, &Any{TypeURL: "type.googleapis.com/example.Square", Value: []byte{0x08, 0x06}}, a)
+	v, err := r.Unpack(a)
+	require.NoError(t, err)
+	assert.Equal(t, square{3}, v)
+
+	a, err = r.Pack(&testStructD{[]byte("raw")})
+	require.NoError(t, err)
+	assert.Equal(t, &Any{TypeURL: "type.googleapis.com/example.D", Value: []byte("raw")}, a)
+	v, err = r.Unpack(a)
+	require.NoError(t, err)
+	assert.Equal(t, &testStructD{[]byte("raw")}, v)
+
+	// A longer path may precede the name.
+	v, err = r.Unpack(&Any{TypeURL: "example.com/x/example.Rect", Value: []byte{0x08, 0x04}})
+	require.NoError(t, err)
+	assert.Equal(t, &rect{W: 2}, v)
+}
+
+func TestPackUnpackSlashedNames(t *testing.T) {
+	r := NewRegistry()
+	_, err := r.RegisterDerived(func() interface{} { return &rect{} })
+	require.NoError(t, err)
+	_, err = r.RegisterType("example.com/pkg.Square", square{})
+	require.NoError(t, err)
+
+	a, err := r.Pack(&rect{W: 1})
+	require.NoError(t, err)
+	assert.Equal(t, AnyTypeURLPrefix+"*go.dedis.ch/protobuf.rect", a.TypeURL)
+	v, err := r.Unpack(a)
+	require.NoError(t, err)
+	assert.Equal(t, &rect{W: 1}, v)
+
+	in := shapes{A: square{3}, B: &rect{2, 5, "r"}}
+	buf, err := EncodeWithOptions(&in, EncodeOptions{Registry: r, AnyInterfaces: true})
+	require.NoError(t, err)
+	var out shapes
+	require.NoError(t, DecodeWithOptions(buf, &out, DecodeOptions{Registry: r, AnyInterfaces: true}))
+	assert.Equal(t, in, out)
+}
+
+func TestPackUnpackErrors(t *testing.T) {
+	r := anyTestRegistry(t)
+
+	// Types must be registered under a name.
+	_, err := r.Pack(&testStructB{})
+	assert.Error(t, err)
+	_, err = r.Pack(&plainImpl{})
+	assert.Error(t, err)
+
+	for _, url := range []string{"", "type.googleapis.com/", "type.googleapis.com/example.Circle"} {
+		_, err = r.Unpack(&Any{TypeURL: url})
+		assert.Error(t, err, url)
+	}
+	_, err = r.Unpack(&Any{TypeURL: "type.googleapis.com/example.Square", Value: []byte{0x08}})
+	assert.Error(t, err)
+}
+
+func TestAnyInterfaces(t *testing.T) {
+	r := anyTestRegistry(t)
+	in := shapes{A: square{3}, B: &rect{2, 5, "r"}}
+	buf, err := EncodeWithOptions(&in, EncodeOptions{Registry: r, AnyInterfaces: true})
+	require.NoError(t, err)
+	url := "type.googleapis.com/example.Square"
+	expected := append([]byte{0x0a, byte(6 + len(url)), 0x0a, byte(len(url))}, url...)
+	expected = append(expected, 0x12, 2, 0x08, 0x06)
+	assert.Equal(t, expected, buf[:len(expected)])
+
+	var out shapes
+	opts := DecodeOptions{Registry: r, AnyInterfaces: true}
+	require.NoError(t, DecodeWithOptions(buf, &out, opts))
+	assert.Equal(t, in, out)
+
+	// The same message can be read with an Any field.
+	var raw struct{ A, B Any }
+	require.NoError(t, Decode(buf, &raw))
+	assert.Equal(t, url, raw.A.TypeURL)
+	v, err := r.Unpack(&raw.B)
+	require.NoError(t, err)
+	assert.Equal(t, in.B, v)
+
+	// The URL must name a type implementing the interface.
+	buf, err = EncodeWithOptions(&testStructHolder{I: &testStructD{}}, EncodeOptions{Registry: r, AnyInterfaces: true})
+	require.NoError(t, err)
+	assert.Error(t, DecodeWithOptions(buf, &out, opts))
+}
+
+func TestGenerateAny(t *testing.T) {
+	type anyHolder struct {
+		S shape
+		A *Any
+	}
+	w := &bytes.Buffer{}
+	opts := GenerateOptions{Proto3: true, AnyInterfaces: true}
+	require.NoError(t, GenerateWithOptions(w, []interface{}{anyHolder{}}, nil, nil, opts))
+	expected := `syntax = "proto3";
+import "google/protobuf/any.proto";
+
+message anyHolder {
+  google.protobuf.Any s = 1;
+  optional google.protobuf.Any a = 2;
+}
+
+`
+	assert.Equal(t, expected, w.String())
+}
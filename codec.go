@@ -0,0 +1,149 @@
+package protobuf
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Codec encodes and decodes the values of a type in a custom way.
+// It lets types the caller doesn't own, such as big.Int or net.IP,
+// be used in messages without wrapper types implementing
+// encoding.BinaryMarshaler. Codec values are always sent
+// as length-delimited fields.
+type Codec interface {
+	// Encode returns the encoding of v, a value of the codec's type.
+	Encode(v interface{}) ([]byte, error)
+
+	// Decode returns the value of the codec's type encoded in buf.
+	Decode(buf []byte) (interface{}, error)
+
+	// ProtoType returns the type of the encoding in .proto files,
+	// such as "bytes", "string" or the name of a message type.
+	ProtoType() string
+}
+
+// DefaultCodecs holds the codecs registered by RegisterCodec,
+// which are used when the options don't name another set.
+var DefaultCodecs = NewCodecs()
+
+// Codecs is a set of codecs for the types they handle.
+// Encoding, decoding and generating .proto files consult the codec
+// registered for a type, if any, before the built-in rules.
+// The codec for a pointer or interface type is only used for non-nil
+// values; nil pointers and interfaces are omitted, as usual.
+// The codec for an interface type may decode values of any type
+// implementing it.
+// Codecs is safe for concurrent use.
+type Codecs struct {
+	lock   sync.RWMutex
+	codecs map[reflect.Type]Codec
+}
+
+// NewCodecs returns an empty set of codecs.
+func NewCodecs() *Codecs {
+	return &Codecs{codecs: make(map[reflect.Type]Codec)}
+}
+
+// Register makes c the codec for values of type t,
+// replacing any codec previously registered for t.
+func (cs *Codecs) Register(t reflect.Type, c Codec) {
+	if t == nil || c == nil {
+		panic("protobuf: Register of nil type or codec")
+	}
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.codecs[t] = c
+}
+
+// Unregister removes the codec registered for t, if any.
+func (cs *Codecs) Unregister(t reflect.Type) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	delete(cs.codecs, t)
+}
+
+// Lookup returns the codec registered for t, or nil if there is none.
+func (cs *Codecs) Lookup(t reflect.Type) Codec {
+	cs.lock.RLock()
+	defer cs.lock.RUnlock()
+	return cs.codecs[t]
+}
+
+// RegisterCodec registers c as the codec for values of type t
+// in DefaultCodecs.
+func RegisterCodec(t reflect.Type, c Codec) {
+	DefaultCodecs.Register(t, c)
+}
+
+func (en *encoder) codecs() *Codecs {
+	if en.opts.Codecs == nil {
+		return DefaultCodecs
+	}
+	return en.opts.Codecs
+}
+
+// codec returns the codec to write val with, or nil if there is none.
+func (en *encoder) codec(val reflect.Value) Codec {
+	switch val.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if val.IsNil() {
+			return nil
+		}
+	}
+	return en.codecs().Lookup(val.Type())
+}
+
+// encodeCodec writes val with key using the codec c.
+func (en *encoder) encodeCodec(key uint64, val reflect.Value, c Codec) error {
+	b, err := c.Encode(val.Interface())
+	if err != nil {
+		return err
+	}
+	en.uvarint(key | 2)
+	en.uvarint(uint64(len(b)))
+	en.Write(b)
+	return nil
+}
+
+func (de *decoder) codecs() *Codecs {
+	if de.opts.Codecs == nil {
+		return DefaultCodecs
+	}
+	return de.opts.Codecs
+}
+
+// decodeCodec sets val to the value the codec c decodes from vb.
+func (de *decoder) decodeCodec(wiretype int, val reflect.Value, vb []byte, c Codec) error {
+	if wiretype != 2 {
+		return fmt.Errorf("bad wiretype for %s", val.Type())
+	}
+	v, err := c.Decode(vb)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		val.Set(reflect.Zero(val.Type()))
+		return nil
+	}
+	nv := reflect.ValueOf(v)
+	if !nv.Type().AssignableTo(val.Type()) {
+		return fmt.Errorf("codec for %s decoded a %s", val.Type(), nv.Type())
+	}
+	val.Set(nv)
+	return nil
+}
+
+// codecTypeName returns the .proto type of values of type t
+// given by a codec, or "" if there is none.
+// Pointers have already been stripped from t,
+// so the codec for a pointer to t is used as well.
+func (g *generator) codecTypeName(t reflect.Type) string {
+	if c := g.codecs.Lookup(t); c != nil {
+		return c.ProtoType()
+	}
+	if c := g.codecs.Lookup(reflect.PtrTo(t)); c != nil {
+		return c.ProtoType()
+	}
+	return ""
+}
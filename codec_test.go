@@ -0,0 +1,137 @@
+package protobuf
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bigIntCodec writes *big.Int values as decimal strings.
+type bigIntCodec struct{}
+
+func (bigIntCodec) Encode(v interface{}) ([]byte, error) {
+	return []byte(v.(*big.Int).String()), nil
+}
+
+func (bigIntCodec) Decode(buf []byte) (interface{}, error) {
+	i, ok := new(big.Int).SetString(string(buf), 10)
+	if !ok {
+		return nil, errors.New("bad big.Int")
+	}
+	return i, nil
+}
+
+func (bigIntCodec) ProtoType() string {
+	return "string"
+}
+
+type celsius float64
+
+// celsiusCodec writes celsius values as decimal strings,
+// which makes repeated fields of them unpacked.
+type celsiusCodec struct{}
+
+func (celsiusCodec) Encode(v interface{}) ([]byte, error) {
+	return strconv.AppendFloat(nil, float64(v.(celsius)), 'g', -1, 64), nil
+}
+
+func (celsiusCodec) Decode(buf []byte) (interface{}, error) {
+	f, err := strconv.ParseFloat(string(buf), 64)
+	return celsius(f), err
+}
+
+func (celsiusCodec) ProtoType() string {
+	return "string"
+}
+
+type withCodecs struct {
+	N     *big.Int
+	Opt   *big.Int
+	Ns    []*big.Int
+	M     map[string]*big.Int
+	Temps []celsius
+}
+
+func testCodecs() *Codecs {
+	cs := NewCodecs()
+	cs.Register(reflect.TypeOf((*big.Int)(nil)), bigIntCodec{})
+	cs.Register(reflect.TypeOf(celsius(0)), celsiusCodec{})
+	return cs
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	cs := testCodecs()
+	n, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	in := withCodecs{
+		N:     n,
+		Ns:    []*big.Int{big.NewInt(1), big.NewInt(-2)},
+		M:     map[string]*big.Int{"a": big.NewInt(3)},
+		Temps: []celsius{-40, 21.5},
+	}
+	buf, err := EncodeWithOptions(&in, EncodeOptions{Codecs: cs})
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(buf, append([]byte{0x0a, 31}, n.String()...)))
+
+	var out withCodecs
+	require.NoError(t, DecodeWithOptions(buf, &out, DecodeOptions{Codecs: cs}))
+	assert.Equal(t, in, out)
+}
+
+func TestCodecUnpacked(t *testing.T) {
+	cs := testCodecs()
+	in := withCodecs{N: big.NewInt(0), Temps: []celsius{1, 2}}
+	buf, err := EncodeWithOptions(&in, EncodeOptions{Codecs: cs})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x0a, 1, '0', 0x2a, 1, '1', 0x2a, 1, '2'}, buf)
+}
+
+func TestCodecErrors(t *testing.T) {
+	cs := testCodecs()
+	var out withCodecs
+	opts := DecodeOptions{Codecs: cs}
+	err := DecodeWithOptions([]byte{0x0a, 1, 'x'}, &out, opts)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "bad big.Int")
+
+	// Codec values are length-delimited.
+	assert.Error(t, DecodeWithOptions([]byte{0x08, 1}, &out, opts))
+}
+
+func TestDefaultCodecs(t *testing.T) {
+	typ := reflect.TypeOf((*big.Int)(nil))
+	RegisterCodec(typ, bigIntCodec{})
+	defer DefaultCodecs.Unregister(typ)
+
+	in := struct{ N *big.Int }{big.NewInt(42)}
+	buf, err := Encode(&in)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x0a, 2, '4', '2'}, buf)
+
+	// Options naming another set don't use DefaultCodecs.
+	_, err = EncodeWithOptions(&in, EncodeOptions{Codecs: NewCodecs()})
+	assert.Error(t, err)
+}
+
+func TestGenerateCodecs(t *testing.T) {
+	w := &bytes.Buffer{}
+	opts := GenerateOptions{Codecs: testCodecs()}
+	err := GenerateWithOptions(w, []interface{}{withCodecs{}}, nil, nil, opts)
+	require.NoError(t, err)
+	expected := `
+message withCodecs {
+  optional string n = 1;
+  optional string opt = 2;
+  repeated string ns = 3;
+  required map<string, string> m = 4;
+  repeated string temps = 5;
+}
+
+`
+	assert.Equal(t, expected, w.String())
+}
@@ -1,6 +1,7 @@
 package protobuf
 
 import (
+	"errors"
 	"reflect"
 	"strings"
 	"testing"
@@ -14,3 +15,91 @@ func TestConstructorString(t *testing.T) {
 		t.Fatal("unexpected constructor string: ", c)
 	}
 }
+
+type animal interface {
+	Sound() string
+}
+
+type dog struct{ Name string }
+
+func (d *dog) Sound() string { return d.Name + ": woof" }
+
+type cat struct{ Name string }
+
+func (c *cat) Sound() string { return c.Name + ": meow" }
+
+type pet struct {
+	Kind    string
+	Animal  animal
+	Friends []animal
+}
+
+func TestContextConstructors(t *testing.T) {
+	in := pet{
+		Kind:    "dog",
+		Animal:  &dog{"rex"},
+		Friends: []animal{&dog{"fido"}},
+	}
+	buf, err := Encode(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	cons := ContextConstructors{
+		reflect.TypeOf((*animal)(nil)).Elem(): func(ctx ConstructorContext) (interface{}, error) {
+			paths = append(paths, ctx.Path)
+			if ctx.Context != "ctx" || ctx.Field == nil {
+				t.Errorf("bad constructor context %+v", ctx)
+			}
+			// Kind comes first, so it has been decoded.
+			if ctx.Parent.(*pet).Kind == "dog" {
+				return &dog{}, nil
+			}
+			return &cat{}, nil
+		},
+	}
+	var out pet
+	opts := DecodeOptions{ContextConstructors: cons, Context: "ctx"}
+	if err := DecodeWithOptions(buf, &out, opts); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("decoded %+v, expected %+v", out, in)
+	}
+	if !reflect.DeepEqual(paths, []string{"pet.animal", "pet.friends[0]"}) {
+		t.Fatalf("unexpected paths %v", paths)
+	}
+
+	// Context constructors take precedence over plain ones.
+	opts.Constructors = Constructors{
+		reflect.TypeOf((*animal)(nil)).Elem(): func() interface{} { return &cat{} },
+	}
+	out = pet{}
+	if err := DecodeWithOptions(buf, &out, opts); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("decoded %+v, expected %+v", out, in)
+	}
+}
+
+func TestContextConstructorErrors(t *testing.T) {
+	buf, err := Encode(&pet{Kind: "dog", Animal: &dog{"rex"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	typ := reflect.TypeOf((*animal)(nil)).Elem()
+	for _, newfunc := range []func(ConstructorContext) (interface{}, error){
+		func(ConstructorContext) (interface{}, error) { return nil, errors.New("no animal") },
+		func(ConstructorContext) (interface{}, error) { return nil, nil },
+		func(ConstructorContext) (interface{}, error) { return dog{}, nil },
+	} {
+		var out pet
+		opts := DecodeOptions{ContextConstructors: ContextConstructors{typ: newfunc}}
+		err := DecodeWithOptions(buf, &out, opts)
+		if _, ok := err.(*DecodeError); !ok {
+			t.Errorf("expected a DecodeError, got %v", err)
+		}
+	}
+}
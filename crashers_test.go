@@ -1,6 +1,8 @@
 package protobuf
 
 import (
+	"errors"
+	"reflect"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -33,7 +35,15 @@ func TestCrash1(t *testing.T) {
 	var s t2
 	err = Decode(in, &s)
 	assert.NotNil(t, err)
-	assert.Equal(t, "Error while decoding field {Name:T3s PkgPath: Type:[3]protobuf.t3 Tag: Offset:112 Index:[4] Anonymous:false}: append to non-slice", err.Error())
+	assert.Equal(t, "protobuf: decoding t2.t3s (field 5, wire type 2) at offset 0: append to non-slice", err.Error())
+
+	var de *DecodeError
+	assert.True(t, errors.As(err, &de))
+	assert.Equal(t, reflect.TypeOf([3]t3{}), de.Type)
+	assert.Equal(t, "t2.t3s", de.Path)
+	assert.Equal(t, int64(5), de.Field)
+	assert.Equal(t, 2, de.WireType)
+	assert.Equal(t, 0, de.Offset)
 }
 
 func TestCrash2(t *testing.T) {
@@ -42,5 +52,10 @@ func TestCrash2(t *testing.T) {
 	var s t2
 	err := Decode(in, &s)
 	assert.NotNil(t, err)
-	assert.Equal(t, "Error while decoding field {Name:X PkgPath: Type:protobuf.t1 Tag: Offset:0 Index:[0] Anonymous:false}: array length and buffer length differ", err.Error())
+	assert.Equal(t, "protobuf: decoding t2.x (field 1, wire type 2) at offset 0: array length and buffer length differ", err.Error())
+
+	var de *DecodeError
+	assert.True(t, errors.As(err, &de))
+	assert.Equal(t, reflect.TypeOf(t1{}), de.Type)
+	assert.Equal(t, int64(1), de.Field)
 }
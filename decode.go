@@ -29,38 +29,195 @@ func (c *Constructors) String() string {
 	return s
 }
 
+// ConstructorContext tells a ContextConstructors function
+// which interface value it instantiates.
+type ConstructorContext struct {
+	// Context is the value of DecodeOptions.Context.
+	Context interface{}
+
+	// Path locates the value being decoded, as in a DecodeError.
+	Path string
+
+	// Field is the field holding the value being decoded.
+	Field *ProtoField
+
+	// Parent points to the struct holding the field.
+	// Only the fields preceding the value in the input
+	// have been decoded yet.
+	Parent interface{}
+}
+
+// ContextConstructors are like Constructors, but the functions
+// are told where the value they instantiate is decoded,
+// so that they can choose its type from a sibling field,
+// or from the context passed through DecodeOptions.
+type ContextConstructors map[reflect.Type]func(ConstructorContext) (interface{}, error)
+
+// DecodeOptions control how DecodeWithOptions reads protocol buffers.
+type DecodeOptions struct {
+	// Constructors instantiate interface types,
+	// as for DecodeWithConstructors.
+	Constructors Constructors
+
+	// ContextConstructors instantiate interface types,
+	// taking precedence over Constructors.
+	ContextConstructors ContextConstructors
+
+	// Context is passed to ContextConstructors.
+	Context interface{}
+
+	// Registry identifies the implementations of interfaces,
+	// or DefaultRegistry if nil.
+	Registry *Registry
+
+	// Codecs decode values of the types they are registered for,
+	// or DefaultCodecs if nil.
+	Codecs *Codecs
+
+	// WellKnownTypes reads dynamic values as google.protobuf.Value,
+	// Struct and ListValue messages, and optional scalars as wrappers
+	// such as google.protobuf.StringValue, as if all fields had the
+	// "wkt" tag option. Repeated time.Duration fields are read as
+	// Duration messages only if they have the tag option, and other
+	// time.Time and time.Duration fields are recognised in either form.
+	WellKnownTypes bool
+
+	// AnyInterfaces reads interface values as google.protobuf.Any
+	// messages, as written with EncodeOptions.AnyInterfaces.
+	// It takes precedence over Envelope.
+	AnyInterfaces bool
+
+	// Envelope selects the format of interface values.
+	Envelope EnvelopeMode
+
+	// Strict makes decoding fail with a *RequiredFieldsError
+	// if any required field is missing from the input,
+	// including inside embedded messages.
+	// Pointer fields are only required if tagged "req".
+	// Repeated fields, maps and interfaces not tagged "req"
+	// may always be absent.
+	Strict bool
+
+	// Proto3 selects proto3 semantics for every message,
+	// as if all struct types implemented Proto3Message.
+	// Absent fields decode as zero values in either case,
+	// but in proto3 no field is required, even when Strict is set.
+	Proto3 bool
+
+	// The following limits protect against hostile or corrupt input.
+	// Exceeding one of them makes decoding fail with a *LimitError,
+	// wrapped in a *DecodeError that locates the offending field.
+	// A limit of zero means no limit.
+
+	// MaxDepth limits how deeply messages may be nested,
+	// counting the message passed to Decode as depth 1.
+	MaxDepth int
+
+	// MaxMessageSize limits the size in bytes of the input buffer,
+	// and of any message decoded into an interface field.
+	MaxMessageSize int
+
+	// MaxRepeatedElements limits the number of elements
+	// decoded into any one repeated field.
+	MaxRepeatedElements int
+
+	// MaxMapEntries limits the number of entries
+	// decoded into any one map field.
+	MaxMapEntries int
+}
+
 // Decoder is the main struct used to decode a protobuf blob.
 type decoder struct {
-	nm Constructors
+	opts    DecodeOptions
+	path    fieldPath
+	depth   int
+	missing []string
+	parents []reflect.Value // Structs being decoded, innermost last
+	key     fieldKey        // Innermost field key read, for errors from panics
+}
+
+// fieldKey locates a field key within the input passed to Decode.
+type fieldKey struct {
+	field    int64
+	wiretype int
+	off      int
+}
+
+func (de *decoder) registry() *Registry {
+	if de.opts.Registry == nil {
+		return DefaultRegistry
+	}
+	return de.opts.Registry
+}
+
+// wrap turns err into a DecodeError for a value of type t
+// in field number field at the current field path, unless it already is one.
+// The offset off is relative to the start of the input passed to Decode.
+func (de *decoder) wrap(err error, t reflect.Type, field int64, wiretype int, off int) error {
+	if _, ok := err.(*DecodeError); ok {
+		return err
+	}
+	return &DecodeError{
+		Type:     t,
+		Path:     de.path.String(),
+		Field:    field,
+		WireType: wiretype,
+		Offset:   off,
+		Err:      err,
+	}
 }
 
 // Decode a protocol buffer into a Go struct.
 // The caller must pass a pointer to the struct to decode into.
+// Malformed input is reported as a *DecodeError,
+// and so are Go types that cannot be decoded into, such as those
+// with invalid struct tags.
 //
-// Decode() currently does not explicitly check that all 'required' fields
+// Decode() does not check that all 'required' fields
 // are actually present in the input buffer being decoded.
 // If required fields are missing, then the corresponding fields
 // will be left unmodified, meaning they will take on
 // their default Go zero values if Decode() is passed a fresh struct.
+// Use DecodeWithOptions with Strict set to detect missing fields.
 func Decode(buf []byte, structPtr interface{}) error {
 	return DecodeWithConstructors(buf, structPtr, nil)
 }
 
 // DecodeWithConstructors is like Decode, but you can pass a map of
 // constructors with which to instantiate interface types.
-func DecodeWithConstructors(buf []byte, structPtr interface{}, cons Constructors) (err error) {
+func DecodeWithConstructors(buf []byte, structPtr interface{}, cons Constructors) error {
+	return DecodeWithOptions(buf, structPtr, DecodeOptions{Constructors: cons})
+}
+
+// DecodeWithOptions is like Decode, but the decoding is controlled by opts.
+func DecodeWithOptions(buf []byte, structPtr interface{}, opts DecodeOptions) (err error) {
+	de := decoder{opts: opts, key: fieldKey{wiretype: -1}}
+	t := reflect.TypeOf(structPtr)
+	if t != nil {
+		de.path.root = typeIndirect(t).Name()
+	}
 	defer func() {
 		if r := recover(); r != nil {
-			switch e := r.(type) {
-			case string:
-				err = errors.New(e)
-			case error:
-				err = e
-			default:
-				err = errors.New("Failed to decode the field")
+			// Report the innermost field being decoded.
+			if f := de.path.field(); f != nil {
+				t = f.Field.Type
 			}
+			err = de.wrap(panicError(r), t, de.key.field, de.key.wiretype, de.key.off)
 		}
 	}()
+
+	if err := de.decode(buf, 0, structPtr); err != nil {
+		return err
+	}
+	if len(de.missing) > 0 {
+		return &RequiredFieldsError{Fields: de.missing}
+	}
+	return nil
+}
+
+// Decode a protocol buffer into the value pointed to by structPtr.
+// The offset off locates buf within the input passed to Decode.
+func (de *decoder) decode(buf []byte, off int, structPtr interface{}) error {
 	if structPtr == nil {
 		return nil
 	}
@@ -69,21 +226,23 @@ func DecodeWithConstructors(buf []byte, structPtr interface{}, cons Constructors
 		return bu.UnmarshalBinary(buf)
 	}
 
-	de := decoder{cons}
 	val := reflect.ValueOf(structPtr)
 	// if its NOT a pointer, it is bad return an error
 	if val.Kind() != reflect.Ptr {
 		return errors.New("Decode has been given a non pointer type")
 	}
-	return de.message(buf, val.Elem())
+	return de.message(buf, off, val.Elem())
 }
 
 // Decode a Protocol Buffers message into a Go struct.
 // The Kind of the passed value v must be Struct.
-func (de *decoder) message(buf []byte, sval reflect.Value) error {
+func (de *decoder) message(buf []byte, off int, sval reflect.Value) error {
 	if sval.Kind() != reflect.Struct {
 		return errors.New("not a struct")
 	}
+	if max := de.opts.MaxMessageSize; max > 0 && len(buf) > max {
+		return de.wrap(&LimitError{"MaxMessageSize", max}, sval.Type(), 0, -1, off)
+	}
 
 	for i := 0; i < sval.NumField(); i++ {
 		switch field := sval.Field(i); field.Kind() {
@@ -97,61 +256,135 @@ func (de *decoder) message(buf []byte, sval reflect.Value) error {
 		}
 	}
 
-	// Decode all the fields
-	fields := ProtoFields(sval.Type())
-	fieldi := 0
+	// Unlike other interfaces, oneof members can always be instantiated.
+	for _, f := range getTypeInfo(sval.Type()).oneofs {
+		field := sval.FieldByIndex(f.Index)
+		field.Set(reflect.Zero(field.Type()))
+	}
+
+	return de.merge(buf, off, sval)
+}
+
+// Merge a Protocol Buffers message into a Go struct,
+// leaving the fields that don't occur in buf unmodified.
+// Fields may occur in any order and any number of times:
+// the last occurrence of a scalar wins, repeated fields are appended to,
+// and embedded messages are merged, as the protobuf spec requires.
+func (de *decoder) merge(buf []byte, off int, sval reflect.Value) error {
+	de.depth++
+	defer func() { de.depth-- }()
+	if max := de.opts.MaxDepth; max > 0 && de.depth > max {
+		return de.wrap(&LimitError{"MaxDepth", max}, sval.Type(), 0, -1, off)
+	}
+	de.parents = append(de.parents, sval)
+	defer func() { de.parents = de.parents[:len(de.parents)-1] }()
+
+	info := getTypeInfo(sval.Type())
+	var seen map[int64]bool
+	if de.opts.Strict && !de.opts.Proto3 && !info.proto3 {
+		seen = map[int64]bool{}
+	}
+	end := off + len(buf)
 	for len(buf) > 0 {
 		// Parse the key
+		keyOff := end - len(buf)
+		start := buf
 		key, n := binary.Uvarint(buf)
 		if n <= 0 {
-			return errors.New("bad protobuf field key")
+			return de.wrap(errors.New("bad protobuf field key"),
+				sval.Type(), 0, -1, keyOff)
 		}
 		buf = buf[n:]
 		wiretype := int(key & 7)
 		fieldnum := key >> 3
+		de.key = fieldKey{int64(fieldnum), wiretype, keyOff}
 
 		// Lookup the corresponding struct field.
-		// Leave field with a zero Value if fieldnum is out-of-range.
+		// Leave field with a zero Value if fieldnum is unknown.
 		// In this case, as well as for blank fields,
 		// value() will just skip over and discard the field content.
 		var field reflect.Value
-		for fieldi < len(fields) && fields[fieldi].ID < int64(fieldnum) {
-			fieldi++
+		var fieldType reflect.Type
+		pf := info.byID[int64(fieldnum)]
+		var oneof *oneofTarget
+		if pf == nil {
+			if oneof = findOneofTarget(info, sval, int64(fieldnum)); oneof != nil {
+				pf = oneof.member.field
+				field = oneof.value()
+			}
 		}
-
-		if fieldi < len(fields) && fields[fieldi].ID == int64(fieldnum) {
+		if oneof != nil {
+			fieldType = pf.Field.Type
+			de.path.push(pf)
+		} else if pf != nil {
 			// For fields within embedded structs, ensure the embedded values aren't nil.
-			index := fields[fieldi].Index
-			path := make([]int, 0, len(index))
-			for _, id := range index {
+			path := make([]int, 0, len(pf.Index))
+			for _, id := range pf.Index {
 				path = append(path, id)
 				field = sval.FieldByIndex(path)
 				if field.Kind() == reflect.Ptr && field.IsNil() {
 					field.Set(reflect.New(field.Type().Elem()))
 				}
 			}
+			fieldType = pf.Field.Type
+			if seen != nil {
+				seen[pf.ID] = true
+			}
+			de.path.push(pf)
 		}
 
-		// For more debugging output, uncomment the following three lines.
-		// if fieldi < len(fields){
-		//   fmt.Printf("Decoding FieldName %+v\n", fields[fieldi].Field)
-		// }
 		// Decode the field's value
-		rem, err := de.value(wiretype, buf, field)
+		rem, err := de.value(wiretype, buf, keyOff+n, field)
+		if err != nil {
+			err = de.wrap(err, fieldType, int64(fieldnum), wiretype, keyOff)
+		}
+		if pf != nil {
+			de.path.pop()
+		}
 		if err != nil {
-			if fieldi < len(fields) && fields[fieldi] != nil {
-				return fmt.Errorf("Error while decoding field %+v: %v", fields[fieldi].Field, err)
-			}
-
 			return err
 		}
+		if oneof != nil {
+			oneof.store()
+		}
+		if pf == nil && info.unknown != nil {
+			// Keep the raw field for Encode to write back out.
+			unknown := sval.FieldByIndex(info.unknown)
+			raw := start[:len(start)-len(rem)]
+			unknown.SetBytes(append(unknown.Bytes(), raw...))
+		}
 		buf = rem
 	}
+
+	if seen != nil {
+		for _, pf := range info.fields {
+			if !seen[pf.ID] && pf.mustOccur() && sval.FieldByIndex(pf.Index[:1]).CanSet() {
+				de.missing = append(de.missing, de.path.child(pf))
+			}
+		}
+	}
 	return nil
 }
 
+// mustOccur reports whether a strict decode requires f to be present.
+// Encode omits empty repeated fields and maps and nil interfaces,
+// so those are only required when explicitly tagged as such.
+func (f *ProtoField) mustOccur() bool {
+	if !f.Required() {
+		return false
+	}
+	switch t := f.Field.Type; t.Kind() {
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.Uint8 || f.Prefix == TagRequired
+	case reflect.Map, reflect.Interface:
+		return f.Prefix == TagRequired
+	}
+	return true
+}
+
 // Pull a value from the buffer and put it into a reflective Value.
-func (de *decoder) value(wiretype int, buf []byte,
+// The offset off locates buf within the input passed to Decode.
+func (de *decoder) value(wiretype int, buf []byte, off int,
 	val reflect.Value) ([]byte, error) {
 
 	// Break out the value from the buffer based on the wire type
@@ -198,6 +431,7 @@ func (de *decoder) value(wiretype int, buf []byte,
 		}
 		vb = buf[n : n+int(v) : n+int(v)]
 		buf = buf[n+int(v):]
+		off += n
 
 	default:
 		return nil, errors.New("unknown protobuf wire-type")
@@ -205,7 +439,7 @@ func (de *decoder) value(wiretype int, buf []byte,
 
 	// We've gotten the value out of the buffer,
 	// now put it into the appropriate reflective Value.
-	if err := de.putvalue(wiretype, val, v, vb); err != nil {
+	if err := de.putvalue(wiretype, val, v, vb, off); err != nil {
 		return nil, err
 	}
 	return buf, nil
@@ -213,7 +447,7 @@ func (de *decoder) value(wiretype int, buf []byte,
 
 func (de *decoder) decodeSignedInt(wiretype int, v uint64) (int64, error) {
 	if wiretype == 0 { // encoded as varint
-		sv := int64(v) >> 1
+		sv := int64(v >> 1)
 		if v&1 != 0 {
 			sv = ^sv
 		}
@@ -227,14 +461,48 @@ func (de *decoder) decodeSignedInt(wiretype int, v uint64) (int64, error) {
 	}
 }
 
+// fieldEncoding returns the encoding option of the field being decoded.
+func (de *decoder) fieldEncoding() TagEncoding {
+	if f := de.path.field(); f != nil {
+		return f.Encoding
+	}
+	return EncodingDefault
+}
+
+// putTwosComplement sets a signed integer value, such as an Int32,
+// from a two's-complement varint.
+func (de *decoder) putTwosComplement(wiretype int, val reflect.Value, v uint64) error {
+	if wiretype != 0 {
+		return errors.New("bad wiretype for int")
+	}
+	sv := int64(v)
+	if val.OverflowInt(sv) {
+		return fmt.Errorf("%s value out of range", val.Kind())
+	}
+	val.SetInt(sv)
+	return nil
+}
+
+// Put a value read from the buffer into a reflective Value.
+// For length-delimited values, off locates vb within the input
+// passed to Decode.
 func (de *decoder) putvalue(wiretype int, val reflect.Value,
-	v uint64, vb []byte) error {
+	v uint64, vb []byte, off int) error {
 	// If val is not settable, it either represents an out-of-range field
 	// or an in-range but blank (padding) field in the struct.
 	// In this case, simply ignore and discard the field's content.
 	if !val.CanSet() {
 		return nil
 	}
+	if c := de.codecs().Lookup(val.Type()); c != nil {
+		return de.decodeCodec(wiretype, val, vb, c)
+	}
+	if dynamicTypeName(val.Type()) != "" && de.wellKnown() {
+		if wiretype != 2 {
+			return errors.New("bad wiretype for dynamic value")
+		}
+		return de.putDynamic(val, vb)
+	}
 	switch val.Kind() {
 	case reflect.Bool:
 		if wiretype != 0 {
@@ -245,33 +513,43 @@ func (de *decoder) putvalue(wiretype int, val reflect.Value,
 		}
 		val.SetBool(v != 0)
 
-	case reflect.Int, reflect.Int32, reflect.Int64:
-		// Signed integers may be encoded either zigzag-varint or fixed
-		// Note that protobufs don't support 8- or 16-bit ints.
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// Signed integers may be encoded either zigzag-varint or fixed.
+		// Protobufs have no 8- or 16-bit ints, so those are range-checked.
 		if val.Kind() == reflect.Int && val.Type().Size() < 8 {
 			return errors.New("detected a 32bit machine, please use either int64 or int32")
 		}
+		if val.Type() == durationType && wiretype == 2 {
+			return putDuration(val, vb)
+		}
+		enc := de.fieldEncoding()
+		if enc == EncodingVarint ||
+			enc == EncodingDefault && (val.Type() == int32type || val.Type() == int64type) {
+			return de.putTwosComplement(wiretype, val, v)
+		}
 		sv, err := de.decodeSignedInt(wiretype, v)
 		if err != nil {
-			fmt.Println("Error Reflect.Int for v=", v, "wiretype=", wiretype, "for Value=", val.Type().Name())
 			return err
 		}
+		if val.OverflowInt(sv) {
+			return fmt.Errorf("%s value out of range", val.Kind())
+		}
 		val.SetInt(sv)
 
-	case reflect.Uint, reflect.Uint32, reflect.Uint64:
-		// Varint-encoded 32-bit and 64-bit unsigned integers.
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		// Varint-encoded or fixed unsigned integers.
 		if val.Kind() == reflect.Uint && val.Type().Size() < 8 {
 			return errors.New("detected a 32bit machine, please use either uint64 or uint32")
 		}
-		if wiretype == 0 {
-			val.SetUint(v)
-		} else if wiretype == 5 { // ufixed32
-			val.SetUint(uint64(uint32(v)))
-		} else if wiretype == 1 { // ufixed64
-			val.SetUint(uint64(v))
-		} else {
+		if wiretype == 5 { // ufixed32
+			v = uint64(uint32(v))
+		} else if wiretype != 0 && wiretype != 1 { // varint or ufixed64
 			return errors.New("bad wiretype for uint")
 		}
+		if val.OverflowUint(v) {
+			return fmt.Errorf("%s value out of range", val.Kind())
+		}
+		val.SetUint(v)
 
 	case reflect.Float32:
 		// Fixed-length 32-bit floats.
@@ -296,7 +574,9 @@ func (de *decoder) putvalue(wiretype int, val reflect.Value,
 
 	case reflect.Struct:
 		// Embedded message
-		if val.Type() == timeType {
+		if val.Type() == timeType && wiretype == 2 {
+			return putTimestamp(val, vb)
+		} else if val.Type() == timeType {
 			sv, err := de.decodeSignedInt(wiretype, v)
 			if err != nil {
 				return err
@@ -310,22 +590,29 @@ func (de *decoder) putvalue(wiretype int, val reflect.Value,
 		if wiretype != 2 {
 			return errors.New("bad wiretype for embedded message")
 		}
-		return de.message(vb, val)
+		return de.merge(vb, off, val)
 
 	case reflect.Ptr:
 		// Optional field
+		if wiretype == 2 && de.wrapped(val) {
+			return de.putWrapper(val, vb)
+		}
 		// Instantiate pointer's element type.
 		if val.IsNil() {
-			val.Set(de.instantiate(val.Type().Elem()))
+			nv, err := de.instantiate(val.Type().Elem())
+			if err != nil {
+				return err
+			}
+			val.Set(nv)
 		}
-		return de.putvalue(wiretype, val.Elem(), v, vb)
+		return de.putvalue(wiretype, val.Elem(), v, vb, off)
 
 	case reflect.Slice, reflect.Array:
 		// Repeated field or byte-slice
 		if wiretype != 2 {
-			return errors.New("bad wiretype for repeated field")
+			return de.unpacked(wiretype, val, v, off)
 		}
-		return de.slice(val, vb)
+		return de.slice(val, vb, off)
 	case reflect.Map:
 		if wiretype != 2 {
 			return errors.New("bad wiretype for repeated field")
@@ -334,22 +621,44 @@ func (de *decoder) putvalue(wiretype int, val reflect.Value,
 			// make(map[k]v):
 			val.Set(reflect.MakeMap(val.Type()))
 		}
-		return de.mapEntry(val, vb)
+		return de.mapEntry(val, vb, off)
 	case reflect.Interface:
+		if de.opts.AnyInterfaces {
+			if wiretype != 2 {
+				return errors.New("bad wiretype for Any")
+			}
+			return de.anyValue(val, vb, off)
+		}
+		switch de.opts.Envelope {
+		case EnvelopeAlways:
+			if wiretype != 2 {
+				return errors.New("bad wiretype for interface envelope")
+			}
+			return de.envelope(val, vb, off)
+		case EnvelopeDetect:
+			if wiretype == 2 && de.isEnvelope(vb) {
+				return de.envelope(val, vb, off)
+			}
+		}
+
 		data := vb[:]
 
 		// Abstract field: instantiate via dynamic constructor.
 		if val.IsNil() {
 			id := GeneratorID{}
 			var g InterfaceGeneratorFunc
-			if len(id) < len(vb) {
+			if len(id) <= len(vb) {
 				copy(id[:], vb[:len(id)])
-				g = generators.get(id)
+				g = de.registry().Lookup(id)
 			}
 
 			if g == nil {
 				// Backwards compatible usage of the default constructors
-				val.Set(de.instantiate(val.Type()))
+				nv, err := de.instantiate(val.Type())
+				if err != nil {
+					return err
+				}
+				val.Set(nv)
 			} else {
 				// As pointers to interface are discouraged in Go, we use
 				// the generator only for interface types
@@ -370,47 +679,97 @@ func (de *decoder) putvalue(wiretype int, val reflect.Value,
 		// Decode into the object the interface points to.
 		// XXX perhaps better ONLY to support self-decoding
 		// for interface fields?
-		return Decode(vb, val.Interface())
+		return de.decodeInterface(val, data, off+len(vb)-len(data))
 
 	default:
-		panic("unsupported value kind " + val.Kind().String())
+		return errors.New("unsupported value kind " + val.Kind().String())
 	}
 	return nil
 }
 
+// decodeInterface decodes the message buf into the value held by
+// the interface val. This is a pointer or, for types registered with
+// RegisterType, possibly a struct, which is replaced by the decoded copy.
+func (de *decoder) decodeInterface(val reflect.Value, buf []byte, off int) error {
+	v := val.Elem()
+	if v.Kind() != reflect.Struct {
+		return de.decode(buf, off, val.Interface())
+	}
+	ptr := reflect.New(v.Type())
+	ptr.Elem().Set(v)
+	if err := de.decode(buf, off, ptr.Interface()); err != nil {
+		return err
+	}
+	val.Set(ptr.Elem())
+	return nil
+}
+
 // Instantiate an arbitrary type, handling dynamic interface types.
 // Returns a Ptr value.
-func (de *decoder) instantiate(t reflect.Type) reflect.Value {
+func (de *decoder) instantiate(t reflect.Type) (reflect.Value, error) {
 
 	// If it's an interface type, lookup a dynamic constructor for it.
 	if t.Kind() == reflect.Interface {
-		newfunc, ok := de.nm[t]
+		if newfunc, ok := de.opts.ContextConstructors[t]; ok {
+			v, err := newfunc(de.constructorContext())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			if v == nil || !reflect.TypeOf(v).Implements(t) {
+				return reflect.Value{}, fmt.Errorf("constructor for interface %s returned %T", t, v)
+			}
+			return reflect.ValueOf(v), nil
+		}
+		newfunc, ok := de.opts.Constructors[t]
 		if !ok {
-			panic("no constructor for interface " + t.String())
+			return reflect.Value{}, errors.New("no constructor for interface " + t.String())
 		}
-		return reflect.ValueOf(newfunc())
+		return reflect.ValueOf(newfunc()), nil
 	}
 
 	// Otherwise, for all concrete types, just instantiate directly.
-	return reflect.New(t)
+	return reflect.New(t), nil
+}
+
+// constructorContext describes the value being decoded
+// for a ContextConstructors function.
+func (de *decoder) constructorContext() ConstructorContext {
+	ctx := ConstructorContext{
+		Context: de.opts.Context,
+		Path:    de.path.String(),
+		Field:   de.path.field(),
+	}
+	if n := len(de.parents); n > 0 && de.parents[n-1].CanAddr() {
+		ctx.Parent = de.parents[n-1].Addr().Interface()
+	}
+	return ctx
 }
 
+var int32type = reflect.TypeOf(Int32(0))
+var int64type = reflect.TypeOf(Int64(0))
 var sfixed32type = reflect.TypeOf(Sfixed32(0))
 var sfixed64type = reflect.TypeOf(Sfixed64(0))
 var ufixed32type = reflect.TypeOf(Ufixed32(0))
 var ufixed64type = reflect.TypeOf(Ufixed64(0))
 
 // Handle decoding of slices
-func (de *decoder) slice(slval reflect.Value, vb []byte) error {
+// The offset off locates vb within the input passed to Decode.
+func (de *decoder) slice(slval reflect.Value, vb []byte, off int) error {
 	// Find the element type, and create a temporary instance of it.
 	eltype := slval.Type().Elem()
 	val := reflect.New(eltype).Elem()
+	if slval.Kind() == reflect.Array && eltype.Kind() != reflect.Uint8 {
+		return errors.New("append to non-slice")
+	}
 
 	// Decide on the wiretype to use for decoding.
 	var wiretype int
+	if de.codecs().Lookup(eltype) != nil || eltype == durationType && durationMessages(de.path.field()) {
+		return de.element(slval, vb, off)
+	}
 	switch eltype.Kind() {
-	case reflect.Bool, reflect.Int32, reflect.Int64, reflect.Int,
-		reflect.Uint32, reflect.Uint64, reflect.Uint:
+	case reflect.Bool, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int,
+		reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint, reflect.Uintptr:
 		if (eltype.Kind() == reflect.Int || eltype.Kind() == reflect.Uint) && eltype.Size() < 8 {
 			return errors.New("detected a 32bit machine, please either use (u)int64 or (u)int32")
 		}
@@ -427,6 +786,9 @@ func (de *decoder) slice(slval reflect.Value, vb []byte) error {
 		default:
 			wiretype = 0 // Packed varint representation
 		}
+		if eltype.Kind() != reflect.Bool && de.fieldEncoding() == EncodingFixed {
+			wiretype = int(integerWireType(eltype, EncodingFixed))
+		}
 
 	case reflect.Float32:
 		wiretype = 5 // Packed 32-bit representation
@@ -449,20 +811,27 @@ func (de *decoder) slice(slval reflect.Value, vb []byte) error {
 		return nil
 
 	default: // Other unpacked repeated types
-		// Just unpack and append one value from vb.
-		if err := de.putvalue(2, val, 0, vb); err != nil {
-			return err
-		}
-		if slval.Kind() != reflect.Slice {
-			return errors.New("append to non-slice")
-		}
-		slval.Set(reflect.Append(slval, val))
-		return nil
+		return de.element(slval, vb, off)
 	}
 
 	// Decode packed values from the buffer and append them to the slice.
+	top := de.path.top()
+	end := off + len(vb)
 	for len(vb) > 0 {
-		rem, err := de.value(wiretype, vb, val)
+		elemOff := end - len(vb)
+		if err := de.checkRepeated(slval); err != nil {
+			return de.wrapElem(err, eltype, wiretype, elemOff)
+		}
+		if top >= 0 {
+			de.path.elems[top].index = slval.Len()
+		}
+		rem, err := de.value(wiretype, vb, elemOff, val)
+		if err != nil {
+			err = de.wrapElem(err, eltype, wiretype, elemOff)
+		}
+		if top >= 0 {
+			de.path.elems[top].index = -1
+		}
 		if err != nil {
 			return err
 		}
@@ -472,8 +841,81 @@ func (de *decoder) slice(slval reflect.Value, vb []byte) error {
 	return nil
 }
 
+// element appends one element of a repeated field
+// of a length-delimited type, such as a message, to slval.
+func (de *decoder) element(slval reflect.Value, vb []byte, off int) error {
+	// Just unpack and append one value from vb.
+	eltype := slval.Type().Elem()
+	if slval.Kind() != reflect.Slice {
+		return errors.New("append to non-slice")
+	}
+	// Check the limit before decoding the element, which may be large.
+	if err := de.checkRepeated(slval); err != nil {
+		return de.wrapElem(err, eltype, 2, off)
+	}
+	val := reflect.New(eltype).Elem()
+	top := de.path.top()
+	if top >= 0 {
+		de.path.elems[top].index = slval.Len()
+	}
+	err := de.putvalue(2, val, 0, vb, off)
+	if err != nil {
+		err = de.wrapElem(err, eltype, 2, off)
+	}
+	if top >= 0 {
+		de.path.elems[top].index = -1
+	}
+	if err != nil {
+		return err
+	}
+	slval.Set(reflect.Append(slval, val))
+	return nil
+}
+
+// unpacked appends one element of a repeated scalar field
+// sent in unpacked form, with its own key, to slval.
+func (de *decoder) unpacked(wiretype int, slval reflect.Value, v uint64, off int) error {
+	eltype := slval.Type().Elem()
+	if slval.Kind() != reflect.Slice || !packable(eltype) {
+		return errors.New("bad wiretype for repeated field")
+	}
+	if err := de.checkRepeated(slval); err != nil {
+		return err
+	}
+	val := reflect.New(eltype).Elem()
+	top := de.path.top()
+	if top >= 0 {
+		de.path.elems[top].index = slval.Len()
+		defer func() { de.path.elems[top].index = -1 }()
+	}
+	if err := de.putvalue(wiretype, val, v, nil, off); err != nil {
+		return err
+	}
+	slval.Set(reflect.Append(slval, val))
+	return nil
+}
+
+// checkRepeated returns a LimitError if appending to slval
+// would exceed the MaxRepeatedElements limit.
+func (de *decoder) checkRepeated(slval reflect.Value) error {
+	if max := de.opts.MaxRepeatedElements; max > 0 && slval.Len() >= max {
+		return &LimitError{"MaxRepeatedElements", max}
+	}
+	return nil
+}
+
+// wrapElem is like wrap for an element of the innermost field on the path,
+// such as a member of a repeated field or a map entry.
+func (de *decoder) wrapElem(err error, t reflect.Type, wiretype int, off int) error {
+	var id int64
+	if f := de.path.field(); f != nil {
+		id = f.ID
+	}
+	return de.wrap(err, t, id, wiretype, off)
+}
+
 // Handles the entry k,v of a map[K]V
-func (de *decoder) mapEntry(slval reflect.Value, vb []byte) error {
+func (de *decoder) mapEntry(slval reflect.Value, vb []byte, off int) error {
 	mKey := reflect.New(slval.Type().Key())
 	mVal := reflect.New(slval.Type().Elem())
 	k := mKey.Elem()
@@ -485,21 +927,28 @@ func (de *decoder) mapEntry(slval reflect.Value, vb []byte) error {
 	buf := vb[n:]
 	wiretype := int(key & 7)
 
+	end := off + len(vb)
 	var err error
-	buf, err = de.value(wiretype, buf, k)
+	buf, err = de.value(wiretype, buf, off+n, k)
 	if err != nil {
-		return err
+		return de.wrapElem(err, k.Type(), wiretype, off)
+	}
+	if top := de.path.top(); top >= 0 {
+		de.path.elems[top].key = k
+		defer func() { de.path.elems[top].key = reflect.Value{} }()
 	}
 	for len(buf) > 0 { // for repeated values (slices etc)
+		valOff := end - len(buf)
 		key, n = binary.Uvarint(buf)
 		if n <= 0 {
-			return errors.New("bad protobuf field key")
+			return de.wrapElem(errors.New("bad protobuf field key"),
+				v.Type(), -1, valOff)
 		}
 		buf = buf[n:]
 		wiretype = int(key & 7)
-		buf, err = de.value(wiretype, buf, v)
+		buf, err = de.value(wiretype, buf, valOff+n, v)
 		if err != nil {
-			return err
+			return de.wrapElem(err, v.Type(), wiretype, valOff)
 		}
 	}
 
@@ -508,6 +957,10 @@ func (de *decoder) mapEntry(slval reflect.Value, vb []byte) error {
 		// Either way, it's an invalid map entry.
 		return errors.New("proto: bad map data: missing key/val")
 	}
+	if max := de.opts.MaxMapEntries; max > 0 && slval.Len() >= max &&
+		!slval.MapIndex(k).IsValid() {
+		return &LimitError{"MaxMapEntries", max}
+	}
 	slval.SetMapIndex(k, v)
 
 	return nil
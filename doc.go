@@ -68,6 +68,16 @@
 //		panic("Decode failed: "+err.Error())
 //	}
 //
+// To write or read several messages on a stream such as a socket or a file,
+// use an Encoder or Decoder, which frame each message with a varint length
+// prefix just like writeDelimitedTo and parseDelimitedFrom do elsewhere:
+//
+//	enc := NewEncoder(conn)
+//	err := enc.Encode(&person)
+//	...
+//	dec := NewDecoder(conn)
+//	err := dec.Decode(&person)	// io.EOF at the end of the stream
+//
 // If you want to interoperate with code in other languages
 // using the same message formats, you may of course still end up writing
 // .proto files for the code in those other languages.
@@ -90,6 +100,11 @@
 //		Field2 int32		// = 3
 //	}
 //
+// Decode normally discards fields whose numbers the Go struct doesn't declare.
+// To keep them, add a field of type UnknownFields, which takes up no field
+// number; Encode writes its content back out, so a program built against an
+// older message definition can relay newer messages without losing data.
+//
 // A 'required' protobuf field translates to a plain field
 // of a corresponding type in the Go struct.
 // The following table summarizes the correspondence between
@@ -99,8 +114,8 @@
 //	--------		--
 //	bool			bool
 //	enum			Enum
-//	int32			uint32
-//	int64			uint64
+//	int32			Int32
+//	int64			Int64
 //	uint32			uint32
 //	uint64			uint64
 //	sint32			int32
@@ -115,6 +130,42 @@
 //	bytes			[]byte
 //	message			struct
 //
+// The other Go integer types are sent as the nearest of these:
+// int as an sint64, uint and uintptr as uint64s,
+// int8 and int16 as sint32s, and uint8 and uint16 as uint32s.
+// Decode returns an error if a value doesn't fit in the Go type.
+//
+// Rather than changing the Go type of a field, you can also choose
+// its encoding with an option in its protobuf tag.
+// The "fixed" option sends integers as fixed32, fixed64, sfixed32 or sfixed64,
+// "zigzag" sends signed integers as sint32 or sint64,
+// "varint" sends integers as int32, int64, uint32 or uint64,
+// and "string" declares a []byte field as a string.
+// For example, this field is a fixed64 with number 3:
+//
+//	Count uint64 `protobuf:"3,fixed"`
+//
+// A time.Time is sent as an sfixed64 count of nanoseconds since 1970,
+// which cannot hold times before 1678 or after 2262, and a time.Duration
+// as an sint64. The "wkt" tag option, or the WellKnownTypes options
+// for a whole call, send them as google.protobuf.Timestamp and
+// google.protobuf.Duration messages instead, which other
+// implementations understand and which hold the zero time.Time.
+// Repeated time.Duration fields are sent as messages only with the tag.
+// The same options let fields of the types interface{},
+// map[string]interface{} and []interface{} hold JSON-like dynamic values,
+// sent as google.protobuf.Value, Struct and ListValue messages,
+// and send optional scalar fields such as *string or *int64
+// as wrappers such as google.protobuf.StringValue or Int64Value.
+//
+// Types the caller doesn't own, such as big.Int or net.IP, can be given
+// an encoding of their own by registering a Codec for them,
+// either globally with RegisterCodec or in a Codecs set
+// named by the options. Codecs take precedence over the rules above.
+// Package go.dedis.ch/protobuf/stdcodec provides codecs
+// for common standard library types,
+// and go.dedis.ch/protobuf/kybercodec for kyber points and scalars.
+//
 // An 'optional' protobuf field is expressed as a pointer field in Go.
 // Encode() will transmit the field only if the pointer is non-nil.
 // Decode() will instantiate the pointed-to type and fill in the pointer
@@ -123,8 +174,19 @@
 //
 // A 'repeated' protobuf field translates to a slice field in Go.
 // Slices of primitive bool, integer, and float types are encoded
-// and decoded in packed format, as if the [packed=true] option
-// was declared for the field in the .proto file.
+// in packed format, as if the [packed=true] option
+// was declared for the field in the .proto file,
+// unless the field is tagged `protobuf:"packed=false"`.
+// Decode accepts both the packed and the unpacked format.
+//
+// By default every non-pointer field is treated as required and always sent.
+// A struct type can opt into proto3 semantics by implementing Proto3Message,
+// or EncodeOptions.Proto3 can select them for a whole call.
+// Non-pointer scalar, string, bytes, repeated and map fields then have
+// implicit presence: they are omitted when they hold their zero value,
+// and decode as zero when absent.
+// GenerateProtobufDefinition emits a matching proto3 file for such types,
+// in which pointer fields keep their explicit presence as optional fields.
 //
 // For flexibility and convenience, struct fields may have interface types,
 // which this package interprets as having dynamic types to be bound at runtime.
@@ -132,15 +194,27 @@
 // to determine the referred-to object's actual type for encoding
 // Decode() takes an optional map of interface types to constructor functions,
 // which it uses to instantiate concrete types for interfaces while decoding.
+// The ContextConstructors of DecodeOptions are also told the field,
+// the partially-decoded parent struct and a per-call context value,
+// so that they can choose the concrete type based on them.
 // Furthermore, if the instantiated types support the Encoding interface,
 // Encode() and Decode() will invoke the methods of that interface,
 // allowing objects to implement their own custom encoding/decoding methods.
+// Types registered in a Registry are tagged with their GeneratorID,
+// so that Decode() can instantiate them without a constructor.
+// RegisterType does so for plain structs, much like gob.Register,
+// so that they need no encoding methods of their own;
+// see EnvelopeMode for a format that keeps the tag apart from the value,
+// and Any for the format other protobuf implementations understand.
+//
+// A oneof group translates to an interface field tagged "oneof",
+// whose possible members are declared with RegisterOneof.
+// Each member is a wrapper struct with a single numbered field,
+// and the field holding it takes up no field number of its own.
 //
 // This package does not try to support all possible protobuf formats.
 // It currently does not support nonzero default value declarations for enums,
-// the legacy unpacked formats for repeated numeric fields,
-// messages with extremely sparse field numbering,
-// or other more exotic features like extensions or oneof.
+// or other more exotic features like extensions.
 // If you need to interoperate with existing protobuf code using these features,
 // then you should probably use goprotobuf,
 // at least for those particular message formats.
@@ -0,0 +1,390 @@
+package protobuf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// With the "wkt" tag option, or the WellKnownTypes options,
+// fields of the types interface{}, map[string]interface{} and []interface{}
+// hold JSON-like dynamic values, sent as the well-known types
+// google.protobuf.Value, Struct and ListValue respectively:
+//
+//	message Value {
+//	  oneof kind {
+//	    NullValue null_value = 1;
+//	    double number_value = 2;
+//	    string string_value = 3;
+//	    bool bool_value = 4;
+//	    Struct struct_value = 5;
+//	    ListValue list_value = 6;
+//	  }
+//	}
+//	message Struct { map<string, Value> fields = 1; }
+//	message ListValue { repeated Value values = 1; }
+//
+// Like encoding/json, Decode represents null as nil, numbers as float64,
+// and nested structs and lists as map[string]interface{} and []interface{}.
+// Encode also accepts other numeric types, as long as the values
+// convert to float64 exactly, and any map with string keys, slice or array.
+
+const structProto = "google/protobuf/struct.proto"
+
+var (
+	dynamicValueType  = reflect.TypeOf((*interface{})(nil)).Elem()
+	dynamicStructType = reflect.TypeOf(map[string]interface{}(nil))
+	dynamicListType   = reflect.TypeOf([]interface{}(nil))
+)
+
+// dynamicTypeName returns the well-known type of the dynamic type t,
+// or "" if t is not one.
+func dynamicTypeName(t reflect.Type) string {
+	switch t {
+	case dynamicValueType:
+		return "google.protobuf.Value"
+	case dynamicStructType:
+		return "google.protobuf.Struct"
+	case dynamicListType:
+		return "google.protobuf.ListValue"
+	}
+	return ""
+}
+
+// dynamic writes the interface{}, map[string]interface{}
+// or []interface{} val with key as a Value, Struct or ListValue message.
+// Nil values are omitted, like other interfaces, maps and slices.
+func (en *encoder) dynamic(key uint64, val reflect.Value) error {
+	if val.IsNil() {
+		return nil
+	}
+	msg := en.nested()
+	var err error
+	switch val.Type() {
+	case dynamicValueType:
+		err = msg.dynamicValue(val.Elem(), 0)
+	case dynamicStructType:
+		err = msg.dynamicStruct(val, 0)
+	default:
+		err = msg.dynamicList(val, 0)
+	}
+	if err != nil {
+		return err
+	}
+	en.uvarint(key | 2)
+	en.uvarint(uint64(msg.Len()))
+	en.Write(msg.Bytes())
+	return nil
+}
+
+// maxDynamicDepth bounds the nesting of dynamic values,
+// which could otherwise recurse forever on cyclic maps.
+const maxDynamicDepth = 10000
+
+// dynamicValue writes the content of a Value message holding v.
+func (en *encoder) dynamicValue(v reflect.Value, depth int) error {
+	for v.IsValid() && (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		en.uvarint(1<<3 | 0)
+		en.uvarint(0)
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		en.uvarint(1<<3 | 0)
+		en.uvarint(0)
+	case reflect.Bool:
+		en.uvarint(4<<3 | 0)
+		if v.Bool() {
+			en.uvarint(1)
+		} else {
+			en.uvarint(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f := float64(v.Int())
+		if f >= math.MaxInt64 || int64(f) != v.Int() {
+			return fmt.Errorf("%d is not exactly a number_value", v.Int())
+		}
+		en.number(f)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		f := float64(v.Uint())
+		if f >= math.MaxUint64 || uint64(f) != v.Uint() {
+			return fmt.Errorf("%d is not exactly a number_value", v.Uint())
+		}
+		en.number(f)
+	case reflect.Float32, reflect.Float64:
+		en.number(v.Float())
+	case reflect.String:
+		b := []byte(v.String())
+		en.uvarint(3<<3 | 2)
+		en.uvarint(uint64(len(b)))
+		en.Write(b)
+	case reflect.Map, reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Map && v.IsNil() || v.Kind() == reflect.Slice && v.IsNil() {
+			en.uvarint(1<<3 | 0)
+			en.uvarint(0)
+			return nil
+		}
+		msg := en.nested()
+		var err error
+		if v.Kind() == reflect.Map {
+			err = msg.dynamicStruct(v, depth+1)
+		} else {
+			err = msg.dynamicList(v, depth+1)
+		}
+		if err != nil {
+			return err
+		}
+		if v.Kind() == reflect.Map {
+			en.uvarint(5<<3 | 2)
+		} else {
+			en.uvarint(6<<3 | 2)
+		}
+		en.uvarint(uint64(msg.Len()))
+		en.Write(msg.Bytes())
+	default:
+		return fmt.Errorf("cannot encode %s as a google.protobuf.Value", v.Type())
+	}
+	return nil
+}
+
+func (en *encoder) number(f float64) {
+	en.uvarint(2<<3 | 1)
+	en.u64(math.Float64bits(f))
+}
+
+// dynamicStruct writes the content of a Struct message holding
+// the map m, which must have string keys.
+func (en *encoder) dynamicStruct(m reflect.Value, depth int) error {
+	if depth > maxDynamicDepth {
+		return errors.New("dynamic value nested too deeply")
+	}
+	if m.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("cannot encode %s as a google.protobuf.Struct", m.Type())
+	}
+	keys := m.MapKeys()
+	if en.opts.Deterministic {
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	}
+	for _, k := range keys {
+		value := en.nested()
+		if err := value.dynamicValue(m.MapIndex(k), depth); err != nil {
+			return err
+		}
+		entry := en.nested()
+		b := []byte(k.String())
+		entry.uvarint(1<<3 | 2)
+		entry.uvarint(uint64(len(b)))
+		entry.Write(b)
+		entry.uvarint(2<<3 | 2)
+		entry.uvarint(uint64(value.Len()))
+		entry.Write(value.Bytes())
+
+		en.uvarint(1<<3 | 2)
+		en.uvarint(uint64(entry.Len()))
+		en.Write(entry.Bytes())
+	}
+	return nil
+}
+
+// dynamicList writes the content of a ListValue message
+// holding the elements of the slice or array l.
+func (en *encoder) dynamicList(l reflect.Value, depth int) error {
+	if depth > maxDynamicDepth {
+		return errors.New("dynamic value nested too deeply")
+	}
+	for i := 0; i < l.Len(); i++ {
+		value := en.nested()
+		if err := value.dynamicValue(l.Index(i), depth); err != nil {
+			return err
+		}
+		en.uvarint(1<<3 | 2)
+		en.uvarint(uint64(value.Len()))
+		en.Write(value.Bytes())
+	}
+	return nil
+}
+
+// putDynamic decodes the Value, Struct or ListValue message vb
+// into the interface{}, map[string]interface{} or []interface{} val.
+// Structs are merged into maps and lists appended to slices,
+// as for other embedded messages.
+func (de *decoder) putDynamic(val reflect.Value, vb []byte) error {
+	switch val.Type() {
+	case dynamicValueType:
+		v, err := de.dynamicValue(vb)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			val.Set(reflect.Zero(val.Type()))
+		} else {
+			val.Set(reflect.ValueOf(v))
+		}
+	case dynamicStructType:
+		if val.IsNil() {
+			val.Set(reflect.MakeMap(val.Type()))
+		}
+		return de.dynamicStruct(val.Interface().(map[string]interface{}), vb)
+	default:
+		l, err := de.dynamicList(val.Interface().([]interface{}), vb)
+		if err != nil {
+			return err
+		}
+		val.Set(reflect.ValueOf(l))
+	}
+	return nil
+}
+
+// dynamicFields calls f with the number, wire type and value
+// of each field of the message vb. For varint and fixed-size fields,
+// the value is the raw bytes of the field.
+func dynamicFields(vb []byte, f func(num uint64, wiretype int, v []byte) error) error {
+	for len(vb) > 0 {
+		key, n := binary.Uvarint(vb)
+		if n <= 0 {
+			return errors.New("bad protobuf field key")
+		}
+		vb = vb[n:]
+		wiretype := int(key & 7)
+		var v, rem []byte
+		var err error
+		if wiretype == 2 {
+			v, rem, err = lengthDelimited(vb)
+		} else {
+			var skip decoder
+			rem, err = skip.value(wiretype, vb, 0, reflect.Value{})
+			if err == nil {
+				v = vb[:len(vb)-len(rem)]
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if err := f(key>>3, wiretype, v); err != nil {
+			return err
+		}
+		vb = rem
+	}
+	return nil
+}
+
+// enter counts one more level of nesting against MaxDepth.
+func (de *decoder) enter() error {
+	de.depth++
+	if max := de.opts.MaxDepth; max > 0 && de.depth > max {
+		return &LimitError{"MaxDepth", max}
+	}
+	if de.depth > maxDynamicDepth {
+		return errors.New("dynamic value nested too deeply")
+	}
+	return nil
+}
+
+// dynamicValue decodes a Value message. A Value without a kind is nil.
+func (de *decoder) dynamicValue(vb []byte) (v interface{}, err error) {
+	if err := de.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { de.depth-- }()
+	err = dynamicFields(vb, func(num uint64, wiretype int, b []byte) error {
+		switch {
+		case num == 1 && wiretype == 0:
+			v = nil
+		case num == 2 && wiretype == 1:
+			v = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		case num == 3 && wiretype == 2:
+			v = string(b)
+		case num == 4 && wiretype == 0:
+			u, _ := binary.Uvarint(b)
+			v = u != 0
+		case num == 5 && wiretype == 2:
+			m := map[string]interface{}{}
+			if err := de.dynamicStruct(m, b); err != nil {
+				return err
+			}
+			v = m
+		case num == 6 && wiretype == 2:
+			l, err := de.dynamicList([]interface{}{}, b)
+			if err != nil {
+				return err
+			}
+			v = l
+		case num >= 1 && num <= 6:
+			return fmt.Errorf("bad wiretype for google.protobuf.Value field %d", num)
+		}
+		return nil
+	})
+	return v, err
+}
+
+// dynamicStruct decodes the fields of a Struct message into m.
+func (de *decoder) dynamicStruct(m map[string]interface{}, vb []byte) error {
+	if err := de.enter(); err != nil {
+		return err
+	}
+	defer func() { de.depth-- }()
+	return dynamicFields(vb, func(num uint64, wiretype int, entry []byte) error {
+		if num != 1 {
+			return nil
+		}
+		if wiretype != 2 {
+			return errors.New("bad wiretype for google.protobuf.Struct field")
+		}
+		var k string
+		var v interface{}
+		err := dynamicFields(entry, func(num uint64, wiretype int, b []byte) error {
+			var err error
+			switch {
+			case num == 1 && wiretype == 2:
+				k = string(b)
+			case num == 2 && wiretype == 2:
+				v, err = de.dynamicValue(b)
+			case num == 1 || num == 2:
+				err = errors.New("bad wiretype for google.protobuf.Struct entry")
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if _, ok := m[k]; !ok {
+			if max := de.opts.MaxMapEntries; max > 0 && len(m) >= max {
+				return &LimitError{"MaxMapEntries", max}
+			}
+		}
+		m[k] = v
+		return nil
+	})
+}
+
+// dynamicList appends the values of a ListValue message to l.
+func (de *decoder) dynamicList(l []interface{}, vb []byte) ([]interface{}, error) {
+	if err := de.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { de.depth-- }()
+	err := dynamicFields(vb, func(num uint64, wiretype int, b []byte) error {
+		if num != 1 {
+			return nil
+		}
+		if wiretype != 2 {
+			return errors.New("bad wiretype for google.protobuf.ListValue field")
+		}
+		if max := de.opts.MaxRepeatedElements; max > 0 && len(l) >= max {
+			return &LimitError{"MaxRepeatedElements", max}
+		}
+		v, err := de.dynamicValue(b)
+		if err != nil {
+			return err
+		}
+		l = append(l, v)
+		return nil
+	})
+	return l, err
+}
@@ -0,0 +1,166 @@
+package protobuf
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type dynamicValues struct {
+	V      interface{}              `protobuf:"wkt"`
+	S      map[string]interface{}   `protobuf:"wkt"`
+	L      []interface{}            `protobuf:"wkt"`
+	Shapes []map[string]interface{} `protobuf:"wkt"`
+}
+
+func TestDynamicWireFormat(t *testing.T) {
+	in := dynamicValues{
+		V: "hi",
+		S: map[string]interface{}{"n": nil},
+		L: []interface{}{true, 1.5},
+	}
+	buf, err := Encode(&in)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x0a, 4, 0x1a, 2, 'h', 'i',
+		0x12, 9, 0x0a, 7, 0x0a, 1, 'n', 0x12, 2, 0x08, 0x00,
+		0x1a, 15, 0x0a, 2, 0x20, 0x01, 0x0a, 9, 0x11, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f,
+	}, buf)
+}
+
+func TestDynamicRoundTrip(t *testing.T) {
+	in := dynamicValues{
+		V: map[string]interface{}{
+			"null":   nil,
+			"number": -2.5,
+			"string": "",
+			"bool":   false,
+			"list":   []interface{}{1.0, "two", []interface{}{}, map[string]interface{}{}},
+			"nested": map[string]interface{}{"a": map[string]interface{}{"b": true}},
+		},
+		S: map[string]interface{}{},
+		L: []interface{}{nil, math.Inf(1)},
+		Shapes: []map[string]interface{}{
+			{"kind": "square", "side": 3.0},
+			{"kind": "circle"},
+		},
+	}
+	buf, err := EncodeWithOptions(&in, EncodeOptions{Deterministic: true})
+	require.NoError(t, err)
+	var out dynamicValues
+	require.NoError(t, Decode(buf, &out))
+	assert.Equal(t, in, out)
+}
+
+func TestDynamicConversions(t *testing.T) {
+	type config struct {
+		Port  int
+		Hosts []string
+	}
+	in := dynamicValues{
+		V: map[string]interface{}{
+			"port":  8080,
+			"ratio": float32(0.5),
+			"hosts": []string{"a", "b"},
+			"ptr":   (*int)(nil),
+		},
+	}
+	buf, err := Encode(&in)
+	require.NoError(t, err)
+	var out dynamicValues
+	require.NoError(t, Decode(buf, &out))
+	assert.Equal(t, map[string]interface{}{
+		"port":  8080.0,
+		"ratio": 0.5,
+		"hosts": []interface{}{"a", "b"},
+		"ptr":   nil,
+	}, out.V)
+
+	for _, v := range []interface{}{
+		int64(1<<53 + 1),
+		uint64(math.MaxUint64),
+		config{},
+		map[int]interface{}{1: 2},
+	} {
+		_, err := Encode(&dynamicValues{V: v})
+		assert.Error(t, err, "%v", v)
+	}
+}
+
+func TestDynamicOption(t *testing.T) {
+	type untagged struct {
+		V interface{}
+		S map[string]interface{}
+	}
+	in := untagged{V: []interface{}{"x"}, S: map[string]interface{}{"k": 1.0}}
+	buf, err := EncodeWithOptions(&in, EncodeOptions{WellKnownTypes: true})
+	require.NoError(t, err)
+	var out untagged
+	require.NoError(t, DecodeWithOptions(buf, &out, DecodeOptions{WellKnownTypes: true}))
+	assert.Equal(t, in, out)
+
+	// Without the option, interface{} needs a constructor as before.
+	assert.Error(t, Decode(buf, &out))
+}
+
+func TestDynamicLimits(t *testing.T) {
+	v := interface{}("leaf")
+	for i := 0; i < 10; i++ {
+		v = []interface{}{v}
+	}
+	buf, err := Encode(&dynamicValues{V: v})
+	require.NoError(t, err)
+	var out dynamicValues
+	err = DecodeWithOptions(buf, &out, DecodeOptions{MaxDepth: 5})
+	var le *LimitError
+	require.True(t, errors.As(err, &le), "expected a LimitError, got %v", err)
+
+	buf, err = Encode(&dynamicValues{L: []interface{}{1.0, 2.0, 3.0}})
+	require.NoError(t, err)
+	err = DecodeWithOptions(buf, &out, DecodeOptions{MaxRepeatedElements: 2})
+	assert.Error(t, err)
+
+	// Replacing an existing entry doesn't count against MaxMapEntries.
+	entry := []byte{0x0a, 7, 0x0a, 1, 'n', 0x12, 2, 0x08, 0x00}
+	buf = append([]byte{0x12, 18}, append(entry, entry...)...)
+	out = dynamicValues{}
+	require.NoError(t, DecodeWithOptions(buf, &out, DecodeOptions{MaxMapEntries: 1}))
+	assert.Equal(t, map[string]interface{}{"n": nil}, out.S)
+	buf, err = Encode(&dynamicValues{S: map[string]interface{}{"a": nil, "b": nil}})
+	require.NoError(t, err)
+	err = DecodeWithOptions(buf, &out, DecodeOptions{MaxMapEntries: 1})
+	require.True(t, errors.As(err, &le), "expected a LimitError, got %v", err)
+
+	// Cyclic values are rejected rather than overflowing the stack.
+	cycle := map[string]interface{}{}
+	cycle["self"] = cycle
+	_, err = Encode(&dynamicValues{S: cycle})
+	assert.Error(t, err)
+}
+
+func TestGenerateDynamic(t *testing.T) {
+	w := &bytes.Buffer{}
+	require.NoError(t, GenerateProtobufDefinition(w, []interface{}{dynamicValues{}}, nil, nil))
+	expected := `import "google/protobuf/struct.proto";
+
+message dynamicValues {
+  optional google.protobuf.Value v = 1;
+  optional google.protobuf.Struct s = 2;
+  optional google.protobuf.ListValue l = 3;
+  repeated google.protobuf.Struct shapes = 4;
+}
+
+`
+	assert.Equal(t, expected, w.String())
+
+	assert.Panics(t, func() {
+		ProtoFields(reflect.TypeOf(struct {
+			M map[string]int `protobuf:"wkt"`
+		}{}))
+	})
+}
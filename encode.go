@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"math"
 	"reflect"
+	"sort"
 	"time"
 )
 
@@ -27,75 +28,245 @@ type Sfixed32 int32
 // will be transmitted as fixed-size 64-bit signed integers.
 type Sfixed64 int64
 
+// Message fields declared to have exactly this type
+// will be transmitted as two's-complement varints, like the int32 type
+// of other protobuf implementations. Negative values take ten bytes.
+type Int32 int32
+
+// Message fields declared to have exactly this type
+// will be transmitted as two's-complement varints, like the int64 type
+// of other protobuf implementations. Negative values take ten bytes.
+type Int64 int64
+
 // Protobufs enums are transmitted as unsigned varints;
 // using this type alias is optional but recommended
 // to ensure they get the correct type.
 type Enum uint32
 
+// EncodeOptions control how EncodeWithOptions translates Go values
+// into protocol buffer format.
+type EncodeOptions struct {
+	// Deterministic causes the entries of every map to be written
+	// in increasing order of their keys, so that encoding the same value
+	// always produces the same bytes. This is needed when the encoding
+	// is signed or hashed, and costs a sort per map.
+	Deterministic bool
+
+	// Proto3 selects proto3 semantics for every message,
+	// as if all struct types implemented Proto3Message.
+	// Fields with implicit presence, that is all but pointers,
+	// embedded messages, interfaces and fixed-size arrays,
+	// are then omitted when they hold their zero value:
+	// zero numbers, false, empty strings, zero time.Time values,
+	// and empty bytes, repeated fields and maps.
+	Proto3 bool
+
+	// Registry identifies the implementations of interfaces,
+	// or DefaultRegistry if nil.
+	Registry *Registry
+
+	// Codecs encode values of the types they are registered for,
+	// or DefaultCodecs if nil.
+	Codecs *Codecs
+
+	// WellKnownTypes writes time.Time values as google.protobuf.Timestamp
+	// and time.Duration values as google.protobuf.Duration messages,
+	// and dynamic interface{}, map[string]interface{} and []interface{}
+	// values as google.protobuf.Value, Struct and ListValue messages,
+	// and optional scalars such as *string as wrappers such as
+	// google.protobuf.StringValue, as if all fields had the "wkt" tag option.
+	// Repeated time.Duration fields are the exception, and are sent
+	// as Duration messages only if they have the tag option.
+	WellKnownTypes bool
+
+	// AnyInterfaces writes interface values as google.protobuf.Any
+	// messages, whose type URLs are derived from the names their types
+	// are registered under. It takes precedence over Envelope.
+	AnyInterfaces bool
+
+	// Envelope writes interface values in envelope messages,
+	// which decoders must read with an EnvelopeMode other than
+	// EnvelopeNever. See EnvelopeMode for details.
+	Envelope bool
+}
+
+// DefaultEncodeOptions are the options used by Encode and by an Encoder
+// that has not been given options of its own.
+var DefaultEncodeOptions EncodeOptions
+
 type encoder struct {
 	bytes.Buffer
+	opts *EncodeOptions
+	path *fieldPath
+	base int // Bytes written by the encoders enclosing en, for errors
+}
+
+// nested returns an encoder for an embedded message or map entry,
+// sharing the options and field path of en.
+func (en *encoder) nested() *encoder {
+	return &encoder{opts: en.opts, path: en.path, base: en.base + en.Len()}
+}
+
+func (en *encoder) registry() *Registry {
+	if en.opts.Registry == nil {
+		return DefaultRegistry
+	}
+	return en.opts.Registry
+}
+
+// errorf returns an EncodeError for the value of type t
+// at the current field path.
+func (en *encoder) errorf(t reflect.Type, format string, args ...interface{}) error {
+	return en.wrap(t, fmt.Errorf(format, args...))
+}
+
+// wrap turns err into an EncodeError for the value of type t
+// at the current field path, unless it already is one.
+func (en *encoder) wrap(t reflect.Type, err error) error {
+	if _, ok := err.(*EncodeError); ok {
+		return err
+	}
+	e := &EncodeError{
+		Type:     t,
+		Path:     en.path.String(),
+		WireType: -1,
+		Offset:   en.base + en.Len(),
+		Err:      err,
+	}
+	if f := en.path.field(); f != nil {
+		e.Field = f.ID
+		if t != nil {
+			e.WireType = en.wireType(t, f)
+		}
+	}
+	return e
 }
 
 // Encode a Go struct into protocol buffer format.
 // The caller must pass a pointer to the struct to encode.
-func Encode(structPtr interface{}) (bytes []byte, err error) {
+// Failures, including invalid struct tags, are reported as an *EncodeError.
+func Encode(structPtr interface{}) ([]byte, error) {
+	return EncodeWithOptions(structPtr, DefaultEncodeOptions)
+}
+
+// EncodeWithOptions is like Encode, but uses opts instead of
+// DefaultEncodeOptions.
+func EncodeWithOptions(structPtr interface{}, opts EncodeOptions) (bytes []byte, err error) {
+	if structPtr == nil {
+		return nil, nil
+	}
+	val := reflect.ValueOf(structPtr)
+	en := encoder{
+		opts: &opts,
+		path: &fieldPath{root: typeIndirect(val.Type()).Name()},
+	}
 	defer func() {
 		if e := recover(); e != nil {
-			err = fmt.Errorf("%v", e)
+			// Report the innermost field being encoded.
+			t := val.Type()
+			if f := en.path.field(); f != nil {
+				t = f.Field.Type
+			}
+			err = en.wrap(t, panicError(e))
 			bytes = nil
 		}
 	}()
-	if structPtr == nil {
-		return nil, nil
-	}
 
 	if bu, ok := structPtr.(encoding.BinaryMarshaler); ok {
-		return bu.MarshalBinary()
+		b, err := bu.MarshalBinary()
+		if err != nil {
+			return nil, en.wrap(val.Type(), err)
+		}
+		return b, nil
 	}
 
-	en := encoder{}
-	val := reflect.ValueOf(structPtr)
 	if val.Kind() != reflect.Ptr {
-		return nil, errors.New("encode takes a pointer to struct")
+		return nil, en.errorf(val.Type(), "encode takes a pointer to struct")
+	}
+	if err := en.message(val.Elem()); err != nil {
+		return nil, err
 	}
-	en.message(val.Elem())
 	return en.Bytes(), nil
 }
 
-func (en *encoder) message(sval reflect.Value) {
-	var index *ProtoField
-	defer func() {
-		if r := recover(); r != nil {
-			if index != nil {
-				panic(fmt.Sprintf("%s (field %s)", r, index.Field.Name))
-			} else {
-				panic(r)
-			}
-		}
-	}()
+func (en *encoder) message(sval reflect.Value) error {
 	// Encode all fields in-order
-	protoFields := ProtoFields(sval.Type())
-	if len(protoFields) == 0 {
-		return
-	}
+	info := getTypeInfo(sval.Type())
+	proto3 := info.proto3 || en.opts.Proto3
 	noPublicFields := true
-	for _, index = range protoFields {
+	for _, index := range info.fields {
 		field := sval.FieldByIndex(index.Index)
 		key := uint64(index.ID) << 3
 		if field.CanSet() { // Skip blank/padding fields
-			en.value(key, field, index.Prefix)
 			noPublicFields = false
+			if proto3 && isProto3Default(field) {
+				continue
+			}
+			en.path.push(index)
+			err := en.value(key, field, index)
+			if err != nil {
+				err = en.wrap(field.Type(), err)
+			}
+			en.path.pop()
+			if err != nil {
+				return err
+			}
 		}
 	}
-	if noPublicFields {
-		panic("struct has no serializable fields")
+	for _, f := range info.oneofs {
+		if err := en.oneof(f, sval); err != nil {
+			return err
+		}
+	}
+	if len(info.fields) > 0 && noPublicFields {
+		return en.errorf(sval.Type(), "struct has no serializable fields")
 	}
+
+	// Pass on any fields collected by Decode that we don't know about.
+	if info.unknown != nil {
+		en.Write(sval.FieldByIndex(info.unknown).Bytes())
+	}
+	return nil
+}
+
+// isProto3Default reports whether v holds the default value
+// of a proto3 field with implicit presence, which proto3 doesn't transmit.
+func isProto3Default(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.String:
+		return v.IsZero()
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.Interface().(time.Time).IsZero()
+		}
+	}
+	return false
 }
 
 var timeType = reflect.TypeOf(time.Time{})
 var durationType = reflect.TypeOf(time.Duration(0))
 
-func (en *encoder) value(key uint64, val reflect.Value, prefix TagPrefix) {
+// value writes val with the given key, which holds the field number.
+// f is the field val belongs to, or nil for elements of repeated fields.
+func (en *encoder) value(key uint64, val reflect.Value, f *ProtoField) error {
+	if c := en.codec(val); c != nil {
+		return en.encodeCodec(key, val, c)
+	}
+	if dynamicTypeName(val.Type()) != "" && en.wellKnown() {
+		return en.dynamic(key, val)
+	}
+	if f != nil && isInteger(val.Kind()) {
+		if enc := f.Encoding; enc != EncodingDefault {
+			en.uvarint(key | integerWireType(val.Type(), enc))
+			en.integer(val, enc)
+			return nil
+		}
+	}
 
 	// Non-reflectively handle some of the fixed types
 	switch v := val.Interface().(type) {
@@ -106,75 +277,97 @@ func (en *encoder) value(key uint64, val reflect.Value, prefix TagPrefix) {
 			vi = 1
 		}
 		en.uvarint(vi)
-		return
+		return nil
 
 	case int:
 		en.uvarint(key | 0)
 		en.svarint(int64(v))
-		return
+		return nil
 
 	case int32:
 		en.uvarint(key | 0)
 		en.svarint(int64(v))
-		return
+		return nil
 
 	case time.Time: // Encode time.Time as sfixed64
+		if en.wellKnown() {
+			return en.timestamp(key, v)
+		}
 		t := v.UnixNano()
 		en.uvarint(key | 1)
 		en.u64(uint64(t))
-		return
+		return nil
 
 	case int64:
 		en.uvarint(key | 0)
 		en.svarint(v)
-		return
+		return nil
+
+	case time.Duration:
+		if en.wellKnown() {
+			en.duration(key, v)
+			return nil
+		}
+		en.uvarint(key | 0)
+		en.svarint(int64(v))
+		return nil
 
 	case uint32:
 		en.uvarint(key | 0)
 		en.uvarint(uint64(v))
-		return
+		return nil
 
 	case uint64:
 		en.uvarint(key | 0)
 		en.uvarint(v)
-		return
+		return nil
+
+	case Int32:
+		en.uvarint(key | 0)
+		en.uvarint(uint64(v))
+		return nil
+
+	case Int64:
+		en.uvarint(key | 0)
+		en.uvarint(uint64(v))
+		return nil
 
 	case Sfixed32:
 		en.uvarint(key | 5)
 		en.u32(uint32(v))
-		return
+		return nil
 
 	case Sfixed64:
 		en.uvarint(key | 1)
 		en.u64(uint64(v))
-		return
+		return nil
 
 	case Ufixed32:
 		en.uvarint(key | 5)
 		en.u32(uint32(v))
-		return
+		return nil
 
 	case Ufixed64:
 		en.uvarint(key | 1)
 		en.u64(uint64(v))
-		return
+		return nil
 
 	case float32:
 		en.uvarint(key | 5)
 		en.u32(math.Float32bits(v))
-		return
+		return nil
 
 	case float64:
 		en.uvarint(key | 1)
 		en.u64(math.Float64bits(v))
-		return
+		return nil
 
 	case string:
 		en.uvarint(key | 2)
 		b := []byte(v)
 		en.uvarint(uint64(len(b)))
 		en.Write(b)
-		return
+		return nil
 	}
 
 	// Handle pointer or interface values (possibly within slices).
@@ -189,14 +382,14 @@ func (en *encoder) value(key uint64, val reflect.Value, prefix TagPrefix) {
 		}
 		en.uvarint(v)
 
-	case reflect.Int, reflect.Int32, reflect.Int64:
-		// Varint-encoded 32-bit and 64-bit signed integers.
-		// Note that protobufs don't support 8- or 16-bit ints.
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// Varint-encoded signed integers.
+		// Protobufs have no 8- or 16-bit ints, so those are sent as 32-bit.
 		en.uvarint(key | 0)
 		en.svarint(val.Int())
 
-	case reflect.Uint32, reflect.Uint64:
-		// Varint-encoded 32-bit and 64-bit unsigned integers.
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		// Varint-encoded unsigned integers.
 		en.uvarint(key | 0)
 		en.uvarint(val.Uint())
 
@@ -220,86 +413,254 @@ func (en *encoder) value(key uint64, val reflect.Value, prefix TagPrefix) {
 	case reflect.Struct:
 		var b []byte
 		if enc, ok := val.Interface().(encoding.BinaryMarshaler); ok {
-			en.uvarint(key | 2)
 			var err error
 			b, err = enc.MarshalBinary()
 			if err != nil {
-				panic(err.Error())
+				return err
 			}
 		} else {
 			// Embedded messages.
-			en.uvarint(key | 2)
-			emb := encoder{}
-			emb.message(val)
+			emb := en.nested()
+			if err := emb.message(val); err != nil {
+				return err
+			}
 			b = emb.Bytes()
 		}
+		en.uvarint(key | 2)
 		en.uvarint(uint64(len(b)))
 		en.Write(b)
+
 	case reflect.Slice, reflect.Array:
 		// Length-delimited slices or byte-vectors.
-		en.slice(key, val)
-		return
+		return en.slice(key, val, f)
 
 	case reflect.Ptr:
 		// Optional field: encode only if pointer is non-nil.
 		if val.IsNil() {
-			if prefix == TagRequired {
-				panic("required field is nil")
+			if f != nil && f.Prefix == TagRequired {
+				return errors.New("required field is nil")
 			}
-			return
+			return nil
 		}
-		en.value(key, val.Elem(), prefix)
+		if en.wrapped(val.Type(), f) {
+			en.wrapper(key, val.Elem())
+			return nil
+		}
+		return en.value(key, val.Elem(), f)
 
 	case reflect.Interface:
 		// Abstract interface field.
 		if val.IsNil() {
-			return
+			return nil
+		}
+		if en.opts.AnyInterfaces {
+			return en.anyValue(key, val)
+		}
+		if en.opts.Envelope {
+			return en.envelope(key, val)
 		}
 
 		// If the object support self-encoding, use that.
 		if enc, ok := val.Interface().(encoding.BinaryMarshaler); ok {
-			en.uvarint(key | 2)
 			bytes, err := enc.MarshalBinary()
 			if err != nil {
-				panic(err.Error())
+				return err
 			}
 
 			size := len(bytes)
-			var id GeneratorID
-			im, ok := val.Interface().(InterfaceMarshaler)
+			id, ok := en.registry().idOf(val.Interface())
 			if ok {
-				id = im.MarshalID()
-
-				g := generators.get(id)
-				ok = g != nil
-				if ok {
-					// add the length of the type tag
-					size += len(id)
-				}
+				// add the length of the type tag
+				size += len(id)
 			}
 
+			en.uvarint(key | 2)
 			en.uvarint(uint64(size))
 			if ok {
 				// Only write the tag if a generator exists
 				en.Write(id[:])
 			}
 			en.Write(bytes)
-			return
+			return nil
+		}
+
+		// Tag registered plain structs with their ID,
+		// so that the decoder can instantiate them.
+		if id, ok := en.registry().idOf(val.Interface()); ok {
+			if sval := reflect.Indirect(val.Elem()); sval.Kind() == reflect.Struct {
+				emb := en.nested()
+				emb.Write(id[:])
+				if err := emb.message(settable(sval)); err != nil {
+					return err
+				}
+				b := emb.Bytes()
+				en.uvarint(key | 2)
+				en.uvarint(uint64(len(b)))
+				en.Write(b)
+				return nil
+			}
 		}
 
 		// Encode from the object the interface points to.
-		en.value(key, val.Elem(), prefix)
+		return en.value(key, val.Elem(), f)
 
 	case reflect.Map:
-		en.handleMap(key, val, prefix)
-		return
+		return en.handleMap(key, val, f)
+
+	default:
+		return fmt.Errorf("unsupported field Kind %d", val.Kind())
+	}
+	return nil
+}
+
+// slice writes a repeated field or byte slice.
+// Repeated scalars are written in packed form
+// unless field f is tagged "packed=false".
+func (en *encoder) slice(key uint64, slval reflect.Value, f *ProtoField) error {
+	sllen := slval.Len()
+	eltype := slval.Type().Elem()
+	if f != nil && f.Encoding != EncodingDefault && isInteger(eltype.Kind()) &&
+		eltype.Kind() != reflect.Uint8 {
+		if f.Unpacked {
+			for i := 0; i < sllen; i++ {
+				en.uvarint(key | integerWireType(eltype, f.Encoding))
+				en.integer(slval.Index(i), f.Encoding)
+			}
+			return nil
+		}
+		packed := encoder{}
+		for i := 0; i < sllen; i++ {
+			packed.integer(slval.Index(i), f.Encoding)
+		}
+		en.uvarint(key | 2)
+		en.uvarint(uint64(packed.Len()))
+		en.Write(packed.Bytes())
+		return nil
+	}
+	if f != nil && f.Unpacked && packable(eltype) || en.codecs().Lookup(eltype) != nil ||
+		eltype == durationType && durationMessages(f) {
+		for i := 0; i < sllen; i++ {
+			if err := en.value(key, slval.Index(i), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return en.packedSlice(key, slval)
+}
+
+func isInteger(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+// integerWireType returns the wire type of integers of type t
+// sent with the encoding enc chosen by a tag option.
+func integerWireType(t reflect.Type, enc TagEncoding) uint64 {
+	if enc != EncodingFixed {
+		return 0
+	}
+	if t.Size() <= 4 {
+		return 5
+	}
+	return 1
+}
 
+// integer writes the integer val with the encoding enc
+// chosen by a tag option, without a key.
+func (en *encoder) integer(val reflect.Value, enc TagEncoding) {
+	var u uint64
+	signed := false
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		u = uint64(val.Int())
+		signed = true
 	default:
-		panic(fmt.Sprintf("unsupported field Kind %d", val.Kind()))
+		u = val.Uint()
+	}
+	switch {
+	case enc == EncodingFixed && val.Type().Size() <= 4:
+		en.u32(uint32(u))
+	case enc == EncodingFixed:
+		en.u64(u)
+	case enc == EncodingZigzag && signed:
+		en.svarint(int64(u))
+	default:
+		en.uvarint(u)
+	}
+}
+
+// settable returns v, or a settable copy of it if it is a struct
+// that isn't, such as one held by value in an interface.
+// The encoder only writes struct fields that can be set.
+func settable(v reflect.Value) reflect.Value {
+	if v.Kind() != reflect.Struct || v.CanSet() {
+		return v
+	}
+	c := reflect.New(v.Type()).Elem()
+	c.Set(v)
+	return c
+}
+
+// wireType returns the wire type a value of type t is written with
+// in field f. Repeated fields written unpacked report that of their elements.
+func (en *encoder) wireType(t reflect.Type, f *ProtoField) int {
+	if en.codecs().Lookup(t) != nil {
+		return 2
+	}
+	if f.Encoding != EncodingDefault && isInteger(t.Kind()) {
+		return int(integerWireType(t, f.Encoding))
+	}
+	switch t.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if t == durationType && en.wellKnown() {
+			return 2
+		}
+		return 0
+	case reflect.Float32:
+		return 5
+	case reflect.Float64:
+		return 1
+	case reflect.Ptr:
+		if en.wrapped(t, f) {
+			return 2
+		}
+		return en.wireType(t.Elem(), f)
+	case reflect.Slice, reflect.Array:
+		elem := t.Elem()
+		if elem.Kind() == reflect.Uint8 || packable(elem) && !f.Unpacked &&
+			!(elem == durationType && durationMessages(f)) && en.codecs().Lookup(elem) == nil {
+			return 2
+		}
+		return en.wireType(elem, f)
+	case reflect.Struct:
+		if t == timeType && !en.wellKnown() {
+			return 1
+		}
 	}
+	return 2
 }
 
-func (en *encoder) slice(key uint64, slval reflect.Value) {
+// packable reports whether repeated fields with elements of type t
+// can use the packed encoding. Slices of uint8 are bytes instead.
+func packable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func (en *encoder) packedSlice(key uint64, slval reflect.Value) error {
 
 	// First handle common cases with a direct typeswitch
 	sllen := slval.Len()
@@ -334,6 +695,16 @@ func (en *encoder) slice(key uint64, slval reflect.Value) {
 			packed.uvarint(slt[i])
 		}
 
+	case []Int32:
+		for i := 0; i < sllen; i++ {
+			packed.uvarint(uint64(slt[i]))
+		}
+
+	case []Int64:
+		for i := 0; i < sllen; i++ {
+			packed.uvarint(uint64(slt[i]))
+		}
+
 	case []Sfixed32:
 		for i := 0; i < sllen; i++ {
 			packed.u32(uint32(slt[i]))
@@ -368,7 +739,7 @@ func (en *encoder) slice(key uint64, slval reflect.Value) {
 		en.uvarint(key | 2)
 		en.uvarint(uint64(sllen))
 		en.Write(slt)
-		return
+		return nil
 
 	case []string:
 		for i := 0; i < sllen; i++ {
@@ -379,10 +750,9 @@ func (en *encoder) slice(key uint64, slval reflect.Value) {
 			en.uvarint(uint64(len(subSlice)))
 			en.Write(subSlice)
 		}
-		return
+		return nil
 	default: // We'll need to use the reflective path
-		en.sliceReflect(key, slval)
-		return
+		return en.sliceReflect(key, slval)
 	}
 
 	// Encode packed representation key/value pair
@@ -390,10 +760,11 @@ func (en *encoder) slice(key uint64, slval reflect.Value) {
 	b := packed.Bytes()
 	en.uvarint(uint64(len(b)))
 	en.Write(b)
+	return nil
 }
 
 // Handle the encoding of an arbritary map[K]V
-func (en *encoder) handleMap(key uint64, mpval reflect.Value, prefix TagPrefix) {
+func (en *encoder) handleMap(key uint64, mpval reflect.Value, f *ProtoField) error {
 	/*
 		A map defined as
 			map<key_type, value_type> map_field = N;
@@ -405,39 +776,108 @@ func (en *encoder) handleMap(key uint64, mpval reflect.Value, prefix TagPrefix)
 			repeated MapFieldEntry map_field = N;
 	*/
 
-	for _, mkey := range mpval.MapKeys() {
+	mkeys := mpval.MapKeys()
+	if en.opts.Deterministic {
+		if err := sortMapKeys(mpval.Type().Key(), mkeys); err != nil {
+			return err
+		}
+	}
+	top := en.path.top()
+	if top >= 0 {
+		defer func() { en.path.elems[top].key = reflect.Value{} }()
+	}
+	for _, mkey := range mkeys {
 		mval := mpval.MapIndex(mkey)
+		if top >= 0 {
+			en.path.elems[top].key = mkey
+		}
 
 		// illegal map entry values
 		// - nil message pointers.
 		switch kind := mval.Kind(); kind {
 		case reflect.Ptr:
 			if mval.IsNil() {
-				panic("proto: map has nil element")
+				return en.errorf(mval.Type(), "proto: map has nil element")
 			}
 		case reflect.Slice, reflect.Array:
 			if mval.Type().Elem().Kind() != reflect.Uint8 {
-				panic("protobuf: map only support []byte or string as repeated value")
+				return en.errorf(mval.Type(), "protobuf: map only support []byte or string as repeated value")
 			}
 		}
 
-		packed := encoder{}
-		packed.value(1<<3, mkey, prefix)
-		packed.value(2<<3, mval, prefix)
+		packed := en.nested()
+		if err := packed.value(1<<3, mkey, f); err != nil {
+			return en.wrap(mkey.Type(), err)
+		}
+		if err := packed.value(2<<3, mval, f); err != nil {
+			return en.wrap(mval.Type(), err)
+		}
 
 		en.uvarint(key | 2)
 		b := packed.Bytes()
 		en.uvarint((uint64(len(b))))
 		en.Write(b)
 	}
+	return nil
+}
+
+// sortMapKeys sorts the keys of a map with key type t in increasing order:
+// false before true, numbers by value, strings and arrays lexicographically.
+func sortMapKeys(t reflect.Type, keys []reflect.Value) error {
+	if !sortableKey(t) {
+		return fmt.Errorf("protobuf: cannot sort map keys of type %s", t)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return lessMapKey(keys[i], keys[j])
+	})
+	return nil
+}
+
+func sortableKey(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.String:
+		return true
+	case reflect.Array:
+		return sortableKey(t.Elem())
+	}
+	return false
+}
+
+func lessMapKey(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Bool:
+		return !a.Bool() && b.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() < b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float()
+	case reflect.String:
+		return a.String() < b.String()
+	case reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			if lessMapKey(a.Index(i), b.Index(i)) {
+				return true
+			}
+			if lessMapKey(b.Index(i), a.Index(i)) {
+				return false
+			}
+		}
+	}
+	return false
 }
 
 var bytesType = reflect.TypeOf([]byte{})
 
-func (en *encoder) sliceReflect(key uint64, slval reflect.Value) {
+func (en *encoder) sliceReflect(key uint64, slval reflect.Value) error {
 	kind := slval.Kind()
 	if kind != reflect.Slice && kind != reflect.Array {
-		panic("no slice passed")
+		return errors.New("no slice passed")
 	}
 	sllen := slval.Len()
 	slelt := slval.Type().Elem()
@@ -452,12 +892,12 @@ func (en *encoder) sliceReflect(key uint64, slval reflect.Value) {
 			packed.uvarint(v)
 		}
 
-	case reflect.Int, reflect.Int32, reflect.Int64:
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		for i := 0; i < sllen; i++ {
 			packed.svarint(slval.Index(i).Int())
 		}
 
-	case reflect.Uint32, reflect.Uint64:
+	case reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
 		for i := 0; i < sllen; i++ {
 			packed.uvarint(slval.Index(i).Uint())
 		}
@@ -490,20 +930,33 @@ func (en *encoder) sliceReflect(key uint64, slval reflect.Value) {
 			b = slval.Convert(bytesType).Interface().([]byte)
 		}
 		en.Write(b)
-		return
+		return nil
 
 	default: // Write each element as a separate key,value pair
 		t := slval.Type().Elem()
 		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
 			subSlice := t.Elem()
 			if subSlice.Kind() != reflect.Uint8 {
-				panic("protobuf: no support for 2-dimensional array except for [][]byte")
+				return errors.New("protobuf: no support for 2-dimensional array except for [][]byte")
 			}
 		}
+		top := en.path.top()
 		for i := 0; i < sllen; i++ {
-			en.value(key, slval.Index(i), TagNone)
+			if top >= 0 {
+				en.path.elems[top].index = i
+			}
+			err := en.value(key, slval.Index(i), nil)
+			if err != nil {
+				err = en.wrap(t, err)
+			}
+			if top >= 0 {
+				en.path.elems[top].index = -1
+			}
+			if err != nil {
+				return err
+			}
 		}
-		return
+		return nil
 	}
 
 	// Encode packed representation key/value pair
@@ -511,6 +964,7 @@ func (en *encoder) sliceReflect(key uint64, slval reflect.Value) {
 	b := packed.Bytes()
 	en.uvarint(uint64(len(b)))
 	en.Write(b)
+	return nil
 }
 
 func (en *encoder) uvarint(v uint64) {
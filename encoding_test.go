@@ -313,7 +313,7 @@ func TestInterface_UnknownType(t *testing.T) {
 	require.Contains(t, err.Error(), unmarshalErr)
 
 	// but not at decoding time
-	generators = newInterfaceRegistry()
+	DefaultRegistry.Unregister((&dummyStruct{}).MarshalID())
 
 	r = dummyWrapper{}
 	err = Decode(buf, &r)
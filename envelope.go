@@ -0,0 +1,209 @@
+package protobuf
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// EnvelopeMode selects how DecodeWithOptions reads interface fields.
+//
+// By default, an interface value is written as its own encoding,
+// prefixed with its GeneratorID if the type is registered.
+// The decoder can't tell such a prefix apart from a payload
+// that happens to start with the ID of a registered type.
+// With EncodeOptions.Envelope set, the value is instead wrapped in
+// an envelope message that keeps the two apart:
+//
+//	message Envelope {
+//	  bytes type_id = 1;  // GeneratorID, or empty if the type isn't registered
+//	  bytes payload = 2;  // or any other wire type the value encodes to
+//	}
+//
+// To migrate a system, first switch the readers to EnvelopeDetect,
+// then the writers to envelopes, then the readers to EnvelopeAlways.
+type EnvelopeMode int
+
+// Possible envelope modes.
+const (
+	// EnvelopeNever reads interface values in the prefixed format.
+	EnvelopeNever EnvelopeMode = iota
+	// EnvelopeAlways reads interface values in envelopes.
+	EnvelopeAlways
+	// EnvelopeDetect reads interface values in either format.
+	// A value is taken to be in an envelope if it consists of
+	// a type_id field, either empty or the ID of a registered type,
+	// and at most one payload field.
+	EnvelopeDetect
+)
+
+const (
+	envelopeTypeID  = 1
+	envelopePayload = 2
+)
+
+// envelope writes the non-nil interface value val with key
+// as an envelope message.
+func (en *encoder) envelope(key uint64, val reflect.Value) error {
+	emb := en.nested()
+	emb.uvarint(envelopeTypeID<<3 | 2)
+	if id, ok := en.registry().idOf(val.Interface()); ok {
+		emb.uvarint(uint64(len(id)))
+		emb.Write(id[:])
+	} else {
+		emb.uvarint(0)
+	}
+
+	if enc, ok := val.Interface().(encoding.BinaryMarshaler); ok {
+		b, err := enc.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		emb.uvarint(envelopePayload<<3 | 2)
+		emb.uvarint(uint64(len(b)))
+		emb.Write(b)
+	} else if err := emb.value(envelopePayload<<3, settable(val.Elem()), nil); err != nil {
+		return err
+	}
+
+	b := emb.Bytes()
+	en.uvarint(key | 2)
+	en.uvarint(uint64(len(b)))
+	en.Write(b)
+	return nil
+}
+
+// envelopeFields holds the fields of a parsed envelope message.
+type envelopeFields struct {
+	id       []byte
+	wiretype int    // Wire type of the payload, or -1 if it is absent
+	value    []byte // Input starting with the payload's value
+	off      int    // Offset of value within the input passed to Decode
+	content  []byte // Content of a length-delimited payload
+	coff     int    // Offset of content within the input passed to Decode
+}
+
+// parseEnvelope splits the envelope message vb into its fields.
+// The offset off locates vb within the input passed to Decode.
+func parseEnvelope(vb []byte, off int) (*envelopeFields, error) {
+	env := &envelopeFields{wiretype: -1}
+	hasID := false
+	end := off + len(vb)
+	for len(vb) > 0 {
+		key, n := binary.Uvarint(vb)
+		if n <= 0 {
+			return nil, errors.New("bad protobuf field key")
+		}
+		vb = vb[n:]
+		wiretype := int(key & 7)
+		switch {
+		case key>>3 == envelopeTypeID && wiretype == 2 && !hasID:
+			id, rem, err := lengthDelimited(vb)
+			if err != nil {
+				return nil, err
+			}
+			env.id = id
+			vb = rem
+			hasID = true
+		case key>>3 == envelopePayload && env.wiretype < 0:
+			env.wiretype = wiretype
+			env.value = vb
+			env.off = end - len(vb)
+			var rem []byte
+			var err error
+			if wiretype == 2 {
+				env.content, rem, err = lengthDelimited(vb)
+				env.coff = end - len(rem) - len(env.content)
+			} else {
+				var skip decoder
+				rem, err = skip.value(wiretype, vb, env.off, reflect.Value{})
+			}
+			if err != nil {
+				return nil, err
+			}
+			vb = rem
+		default:
+			return nil, errors.New("bad interface envelope")
+		}
+	}
+	if !hasID || len(env.id) != 0 && len(env.id) != len(GeneratorID{}) {
+		return nil, errors.New("bad interface envelope type ID")
+	}
+	return env, nil
+}
+
+// lengthDelimited splits a length-delimited value off the start of buf.
+func lengthDelimited(buf []byte) (content, rem []byte, err error) {
+	l, n := binary.Uvarint(buf)
+	if n <= 0 || l > uint64(len(buf)-n) {
+		return nil, nil, errors.New("bad protobuf length-delimited value")
+	}
+	return buf[n : n+int(l)], buf[n+int(l):], nil
+}
+
+// isEnvelope reports whether vb looks like an envelope message,
+// for EnvelopeDetect.
+func (de *decoder) isEnvelope(vb []byte) bool {
+	env, err := parseEnvelope(vb, 0)
+	if err != nil {
+		return false
+	}
+	if len(env.id) == 0 {
+		return true
+	}
+	var id GeneratorID
+	copy(id[:], env.id)
+	return de.registry().Lookup(id) != nil
+}
+
+// envelope decodes the envelope message vb into the interface value val,
+// instantiating it if it is nil.
+// The offset off locates vb within the input passed to Decode.
+func (de *decoder) envelope(val reflect.Value, vb []byte, off int) error {
+	env, err := parseEnvelope(vb, off)
+	if err != nil {
+		return err
+	}
+	if val.IsNil() {
+		if len(env.id) > 0 {
+			var id GeneratorID
+			copy(id[:], env.id)
+			g := de.registry().Lookup(id)
+			if g == nil {
+				return fmt.Errorf("no generator for type ID %x", id[:])
+			}
+			val.Set(reflect.ValueOf(g()))
+		} else {
+			nv, err := de.instantiate(val.Type())
+			if err != nil {
+				return err
+			}
+			val.Set(nv)
+		}
+	}
+
+	// An absent payload is empty.
+	if env.wiretype < 0 {
+		env.wiretype = 2
+	}
+
+	// If the object support self-decoding, use that.
+	if dec, ok := val.Interface().(encoding.BinaryUnmarshaler); ok {
+		if env.wiretype != 2 {
+			return errors.New("bad wiretype for bytes")
+		}
+		return dec.UnmarshalBinary(env.content)
+	}
+
+	if env.wiretype == 2 && typeIndirect(val.Elem().Type()).Kind() == reflect.Struct {
+		return de.decodeInterface(val, env.content, env.coff)
+	}
+	ptr := reflect.ValueOf(val.Interface())
+	if ptr.Kind() != reflect.Ptr {
+		return errors.New("Decode has been given a non pointer type")
+	}
+	_, err = de.value(env.wiretype, env.value, env.off, ptr.Elem())
+	return err
+}
@@ -0,0 +1,115 @@
+package protobuf
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// plainImpl implements testInterface without encoding itself.
+type plainImpl struct {
+	S string
+}
+
+func (p *plainImpl) String() string {
+	return p.S
+}
+
+func envelopeTestSetup(t *testing.T) (*Registry, Constructors) {
+	r := NewRegistry()
+	require.NoError(t, r.Register(func() interface{} { return &testStructB{} }))
+	cons := Constructors{
+		reflect.TypeOf((*testInterface)(nil)).Elem(): func() interface{} {
+			return &testStructD{}
+		},
+	}
+	return r, cons
+}
+
+func TestEnvelopeAmbiguousPrefix(t *testing.T) {
+	r, cons := envelopeTestSetup(t)
+
+	// testStructD isn't registered, and its payload happens
+	// to start with the ID of testStructB.
+	payload := append([]byte{'b', 0, 0, 0, 0, 0, 0, 0}, "xyz"...)
+	in := testStructHolder{I: &testStructD{payload}}
+
+	buf, err := EncodeWithOptions(&in, EncodeOptions{Registry: r})
+	require.NoError(t, err)
+	// The prefixed format is ambiguous: the decoder takes the value
+	// to be a testStructB, and fails to decode it.
+	var out testStructHolder
+	err = DecodeWithOptions(buf, &out, DecodeOptions{Registry: r, Constructors: cons})
+	assert.Error(t, err)
+
+	buf, err = EncodeWithOptions(&in, EncodeOptions{Registry: r, Envelope: true})
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte{0x0a, 15, 0x0a, 0x00, 0x12, 11}, payload...), buf)
+	for _, mode := range []EnvelopeMode{EnvelopeAlways, EnvelopeDetect} {
+		out = testStructHolder{}
+		opts := DecodeOptions{Registry: r, Constructors: cons, Envelope: mode}
+		require.NoError(t, DecodeWithOptions(buf, &out, opts))
+		assert.Equal(t, in, out)
+	}
+}
+
+func TestEnvelopeRegisteredType(t *testing.T) {
+	r, _ := envelopeTestSetup(t)
+	in := testStructHolder{I: &testStructB{}}
+	buf, err := EncodeWithOptions(&in, EncodeOptions{Registry: r, Envelope: true})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x0a, 12, 0x0a, 8, 'b', 0, 0, 0, 0, 0, 0, 0, 0x12, 0}, buf)
+
+	var out testStructHolder
+	opts := DecodeOptions{Registry: r, Envelope: EnvelopeAlways}
+	require.NoError(t, DecodeWithOptions(buf, &out, opts))
+	assert.Equal(t, in, out)
+
+	// The type ID must be known.
+	out = testStructHolder{}
+	opts.Registry = NewRegistry()
+	assert.Error(t, DecodeWithOptions(buf, &out, opts))
+}
+
+func TestEnvelopePlainStruct(t *testing.T) {
+	cons := Constructors{
+		reflect.TypeOf((*testInterface)(nil)).Elem(): func() interface{} {
+			return &plainImpl{}
+		},
+	}
+	in := testStructHolder{I: &plainImpl{"hello"}}
+	buf, err := EncodeWithOptions(&in, EncodeOptions{Envelope: true})
+	require.NoError(t, err)
+
+	var out testStructHolder
+	opts := DecodeOptions{Constructors: cons, Envelope: EnvelopeAlways}
+	require.NoError(t, DecodeWithOptions(buf, &out, opts))
+	assert.Equal(t, in, out)
+}
+
+func TestEnvelopeDetectReadsBothFormats(t *testing.T) {
+	r, cons := envelopeTestSetup(t)
+	opts := DecodeOptions{Registry: r, Constructors: cons, Envelope: EnvelopeDetect}
+	for _, in := range []testStructHolder{
+		{I: &testStructB{}},
+		{I: &testStructD{[]byte("data")}},
+	} {
+		for _, envelope := range []bool{false, true} {
+			buf, err := EncodeWithOptions(&in, EncodeOptions{Registry: r, Envelope: envelope})
+			require.NoError(t, err)
+			var out testStructHolder
+			require.NoError(t, DecodeWithOptions(buf, &out, opts))
+			assert.Equal(t, in, out)
+		}
+	}
+
+	// EnvelopeAlways rejects the prefixed format.
+	in := testStructHolder{I: &testStructB{}}
+	buf, err := EncodeWithOptions(&in, EncodeOptions{Registry: r})
+	require.NoError(t, err)
+	var out testStructHolder
+	opts.Envelope = EnvelopeAlways
+	assert.Error(t, DecodeWithOptions(buf, &out, opts))
+}
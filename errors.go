@@ -0,0 +1,157 @@
+package protobuf
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// EncodeError describes a failure to encode a Go value.
+// It is returned, possibly wrapped, by Encode and EncodeWithOptions.
+type EncodeError struct {
+	Type     reflect.Type // Go type of the value that failed to encode
+	Path     string       // Full path of the field, such as "Person.phone[2].number"
+	Field    int64        // Field number, or 0 if not within a field
+	WireType int          // Wire type the value is written with, or -1 if not within a field
+	Offset   int          // Bytes of output written before the value, excluding the length prefixes of enclosing messages
+	Err      error        // Underlying cause
+}
+
+func (e *EncodeError) Error() string {
+	if e.Field == 0 {
+		return fmt.Sprintf("protobuf: encoding %s at offset %d: %v",
+			e.Path, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("protobuf: encoding %s (field %d, wire type %d) at offset %d: %v",
+		e.Path, e.Field, e.WireType, e.Offset, e.Err)
+}
+
+// Unwrap returns the underlying cause of the error.
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeError describes a failure to decode a protocol buffer,
+// usually because the input is malformed or doesn't match the Go type
+// it is decoded into.
+// It is returned, possibly wrapped, by Decode and its variants.
+type DecodeError struct {
+	Type     reflect.Type // Go type being decoded into, or nil for unknown fields
+	Path     string       // Full path of the field, such as "Person.phone[2].number"
+	Field    int64        // Field number, or 0 if not within a field
+	WireType int          // Wire type read from the input, or -1 if not yet known
+	Offset   int          // Byte offset into the input where the problem starts
+	Err      error        // Underlying cause
+}
+
+func (e *DecodeError) Error() string {
+	if e.Field == 0 {
+		return fmt.Sprintf("protobuf: decoding %s at offset %d: %v",
+			e.Path, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("protobuf: decoding %s (field %d, wire type %d) at offset %d: %v",
+		e.Path, e.Field, e.WireType, e.Offset, e.Err)
+}
+
+// Unwrap returns the underlying cause of the error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// RequiredFieldsError is returned by a strict decode when required fields
+// are missing from the input. Fields holds the full path of each missing
+// field, such as "Person.phone[2].number".
+type RequiredFieldsError struct {
+	Fields []string
+}
+
+func (e *RequiredFieldsError) Error() string {
+	return "protobuf: missing required fields: " + strings.Join(e.Fields, ", ")
+}
+
+// panicError turns a value recovered from a panic,
+// such as one reporting an invalid struct tag, into an error.
+func panicError(r interface{}) error {
+	switch e := r.(type) {
+	case string:
+		return errors.New(e)
+	case error:
+		return e
+	}
+	return fmt.Errorf("%v", r)
+}
+
+// LimitError reports that decoding was stopped
+// because the input exceeded one of the limits set in DecodeOptions.
+type LimitError struct {
+	Limit string // Name of the DecodeOptions field, such as "MaxDepth"
+	Max   int    // Value of that limit
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("protobuf: %s limit of %d exceeded", e.Limit, e.Max)
+}
+
+// pathElem is one step on the path from the message passed to
+// Encode or Decode down to the value currently being processed.
+type pathElem struct {
+	field *ProtoField
+	index int           // index within a repeated field, or -1
+	key   reflect.Value // key within a map field, if valid
+}
+
+// fieldPath tracks where in a message the encoder or decoder is,
+// so that errors can name the offending field.
+type fieldPath struct {
+	root  string
+	elems []pathElem
+}
+
+func (p *fieldPath) push(f *ProtoField) {
+	p.elems = append(p.elems, pathElem{field: f, index: -1})
+}
+
+func (p *fieldPath) pop() {
+	p.elems = p.elems[:len(p.elems)-1]
+}
+
+// top returns the index of the innermost path element, or -1.
+func (p *fieldPath) top() int {
+	return len(p.elems) - 1
+}
+
+// field returns the innermost field on the path, or nil.
+func (p *fieldPath) field() *ProtoField {
+	if len(p.elems) == 0 {
+		return nil
+	}
+	return p.elems[len(p.elems)-1].field
+}
+
+// String formats the path using .proto field names.
+func (p *fieldPath) String() string {
+	return p.child(nil)
+}
+
+// child formats the path to field f of the innermost message,
+// or the path itself if f is nil.
+func (p *fieldPath) child(f *ProtoField) string {
+	namer := DefaultGeneratorNamer{}
+	var b strings.Builder
+	b.WriteString(p.root)
+	for _, e := range p.elems {
+		b.WriteString(".")
+		b.WriteString(namer.FieldName(*e.field))
+		if e.index >= 0 {
+			fmt.Fprintf(&b, "[%d]", e.index)
+		} else if e.key.IsValid() {
+			fmt.Fprintf(&b, "[%v]", e.key.Interface())
+		}
+	}
+	if f != nil {
+		b.WriteString(".")
+		b.WriteString(namer.FieldName(*f))
+	}
+	return b.String()
+}
@@ -0,0 +1,165 @@
+package protobuf
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeErrorMapPath(t *testing.T) {
+	_, err := Encode(&MessageWithMap{
+		NameMapping: map[uint32]string{1: "a"},
+		MsgMapping:  map[int64]*FloatingPoint{1: nil},
+	})
+	var ee *EncodeError
+	require.True(t, errors.As(err, &ee))
+	assert.Equal(t, "MessageWithMap.msg_mapping[1]", ee.Path)
+	assert.Equal(t, int64(3), ee.Field)
+	assert.Equal(t, reflect.TypeOf(&FloatingPoint{}), ee.Type)
+	assert.Equal(t, 2, ee.WireType)
+	assert.Equal(t, 7, ee.Offset)
+	assert.Equal(t, "protobuf: encoding MessageWithMap.msg_mapping[1] (field 3, wire type 2) at offset 7: proto: map has nil element", err.Error())
+}
+
+type floatList struct {
+	List []FloatingPoint
+}
+
+func TestEncodeErrorSlicePath(t *testing.T) {
+	f := 1.0
+	_, err := Encode(&floatList{List: []FloatingPoint{{&f}, {nil}}})
+	var ee *EncodeError
+	require.True(t, errors.As(err, &ee))
+	assert.Equal(t, "floatList.list[1].f", ee.Path)
+	assert.Equal(t, int64(1), ee.Field)
+	assert.Equal(t, reflect.TypeOf(&f), ee.Type)
+	assert.Equal(t, 1, ee.WireType)
+	// The second element starts after the 11 bytes of the first,
+	// and its length prefix isn't counted.
+	assert.Equal(t, 11, ee.Offset)
+	assert.EqualError(t, ee.Err, "required field is nil")
+}
+
+type reusedID struct {
+	A uint32 `protobuf:"1"`
+	B string `protobuf:"1"`
+}
+
+type badEncodingOption struct {
+	S string `protobuf:"fixed"`
+}
+
+type hasBadType struct {
+	X uint32
+	R reusedID
+}
+
+func TestErrorsFromInvalidTypes(t *testing.T) {
+	for _, v := range []interface{}{&reusedID{}, &badEncodingOption{}, &hasBadType{}} {
+		_, err := Encode(v)
+		var ee *EncodeError
+		require.True(t, errors.As(err, &ee), "%T: %v", v, err)
+
+		err = Decode([]byte{0x08, 0x01, 0x12, 0x00}, v)
+		var de *DecodeError
+		require.True(t, errors.As(err, &de), "%T: %v", v, err)
+	}
+
+	// The error locates the field whose type is invalid.
+	_, err := Encode(&hasBadType{X: 1})
+	var ee *EncodeError
+	require.True(t, errors.As(err, &ee))
+	assert.Equal(t, "hasBadType.r", ee.Path)
+	assert.Equal(t, reflect.TypeOf(reusedID{}), ee.Type)
+	assert.Equal(t, int64(2), ee.Field)
+	assert.Equal(t, 2, ee.Offset)
+
+	err = Decode([]byte{0x08, 0x01, 0x12, 0x00}, &hasBadType{})
+	var de *DecodeError
+	require.True(t, errors.As(err, &de))
+	assert.Equal(t, "hasBadType.r", de.Path)
+	assert.Equal(t, reflect.TypeOf(reusedID{}), de.Type)
+	assert.Equal(t, int64(2), de.Field)
+	assert.Equal(t, 2, de.WireType)
+	assert.Equal(t, 2, de.Offset)
+
+	// Packed fields can't be decoded into arrays.
+	err = Decode([]byte{0x0a, 0x01, 0x02}, &struct{ A [3]int32 }{})
+	require.True(t, errors.As(err, &de), "%v", err)
+	assert.Equal(t, int64(1), de.Field)
+	assert.EqualError(t, de.Err, "append to non-slice")
+}
+
+var errMarshal = errors.New("marshal failed")
+
+type failMarshal struct{}
+
+func (failMarshal) MarshalBinary() ([]byte, error) {
+	return nil, errMarshal
+}
+
+func (*failMarshal) UnmarshalBinary([]byte) error {
+	return errMarshal
+}
+
+type hasFailMarshal struct {
+	A uint32
+	F failMarshal
+}
+
+func TestErrorsWrapCause(t *testing.T) {
+	_, err := Encode(&hasFailMarshal{})
+	assert.True(t, errors.Is(err, errMarshal))
+	var ee *EncodeError
+	require.True(t, errors.As(err, &ee))
+	assert.Equal(t, "hasFailMarshal.f", ee.Path)
+
+	err = Decode([]byte{0x08, 0x01, 0x12, 0x00}, &hasFailMarshal{})
+	assert.True(t, errors.Is(err, errMarshal))
+	var de *DecodeError
+	require.True(t, errors.As(err, &de))
+	assert.Equal(t, "hasFailMarshal.f", de.Path)
+	assert.Equal(t, int64(2), de.Field)
+	assert.Equal(t, 2, de.Offset)
+}
+
+func TestDecodeErrorNestedPath(t *testing.T) {
+	buf := []byte{
+		0x0a, 0x01, 'A', // name
+		0x22, 0x03, 0x0a, 0x01, '1', // phone[0].number
+		0x22, 0x02, 0x08, 0x05, // phone[1].number as a varint
+	}
+	err := Decode(buf, &Person{})
+	var de *DecodeError
+	require.True(t, errors.As(err, &de))
+	assert.Equal(t, "Person.phone[1].number", de.Path)
+	assert.Equal(t, reflect.TypeOf(""), de.Type)
+	assert.Equal(t, int64(1), de.Field)
+	assert.Equal(t, 0, de.WireType)
+	assert.Equal(t, 10, de.Offset)
+	assert.EqualError(t, de.Err, "bad wiretype for string")
+}
+
+func TestDecodeErrorPackedElement(t *testing.T) {
+	err := Decode([]byte{0x0a, 0x02, 0x01, 0x80}, &ArrayTest2{})
+	var de *DecodeError
+	require.True(t, errors.As(err, &de))
+	assert.Equal(t, "ArrayTest2.a[1]", de.Path)
+	assert.Equal(t, int64(1), de.Field)
+	assert.Equal(t, 0, de.WireType)
+	assert.Equal(t, 3, de.Offset)
+}
+
+func TestDecodeErrorUnknownField(t *testing.T) {
+	err := Decode([]byte{0x10, 0x02, 0x4f}, &Person{})
+	var de *DecodeError
+	require.True(t, errors.As(err, &de))
+	assert.Equal(t, "Person", de.Path)
+	assert.Nil(t, de.Type)
+	assert.Equal(t, int64(9), de.Field)
+	assert.Equal(t, 7, de.WireType)
+	assert.Equal(t, 2, de.Offset)
+}
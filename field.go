@@ -17,6 +17,38 @@ const (
 	TagRequired
 )
 
+// TagEncoding is a wire encoding chosen by a field's tag,
+// overriding the one implied by its Go type.
+type TagEncoding int
+
+// Possible encoding options.
+const (
+	// EncodingDefault uses the encoding implied by the Go type.
+	EncodingDefault TagEncoding = iota
+	// EncodingFixed ("fixed") sends integers as fixed32, fixed64,
+	// sfixed32 or sfixed64, depending on their size and signedness.
+	EncodingFixed
+	// EncodingZigzag ("zigzag") sends signed integers
+	// as sint32 or sint64 zigzag varints.
+	EncodingZigzag
+	// EncodingVarint ("varint") sends integers as int32, int64,
+	// uint32 or uint64 varints, signed ones in two's complement.
+	EncodingVarint
+	// EncodingString ("string") declares a []byte field as a string
+	// in generated .proto files. The wire format is the same as for bytes.
+	EncodingString
+)
+
+var tagEncodings = map[string]TagEncoding{
+	"fixed":  EncodingFixed,
+	"zigzag": EncodingZigzag,
+	"varint": EncodingVarint,
+	"string": EncodingString,
+}
+
+// ParseTag parses the number, prefix and name in the protobuf tag of field.
+// Other options, such as "packed=false", "wkt" or "fixed", are skipped;
+// see ParseTagEncoding.
 func ParseTag(field reflect.StructField) (id int, opt TagPrefix, name string) {
 	tag := field.Tag.Get("protobuf")
 	if tag == "" {
@@ -28,6 +60,10 @@ func ParseTag(field reflect.StructField) (id int, opt TagPrefix, name string) {
 			opt = TagOptional
 		} else if part == "req" {
 			opt = TagRequired
+		} else if part == "oneof" || part == "wkt" || strings.HasPrefix(part, "packed=") {
+			// See hasTagOption.
+		} else if _, ok := tagEncodings[part]; ok {
+			// See ParseTagEncoding.
 		} else {
 			i, err := strconv.Atoi(part)
 			if err != nil {
@@ -40,6 +76,45 @@ func ParseTag(field reflect.StructField) (id int, opt TagPrefix, name string) {
 	return
 }
 
+// ParseTagEncoding returns the encoding option in the protobuf tag of field,
+// or EncodingDefault if there is none. It returns an error
+// if there are several, or if the option doesn't apply to the field's type.
+func ParseTagEncoding(field reflect.StructField) (TagEncoding, error) {
+	enc := EncodingDefault
+	for _, part := range strings.Split(field.Tag.Get("protobuf"), ",") {
+		if e, ok := tagEncodings[part]; ok {
+			if enc != EncodingDefault {
+				return EncodingDefault, fmt.Errorf("field %s has more than one encoding option", field.Name)
+			}
+			enc = e
+		}
+	}
+	if enc == EncodingDefault {
+		return enc, nil
+	}
+
+	// Options apply to the elements of repeated fields.
+	t := typeIndirect(field.Type)
+	if t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8 {
+		t = typeIndirect(t.Elem())
+	}
+	ok := false
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		ok = enc != EncodingString
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		ok = enc == EncodingFixed || enc == EncodingVarint
+	case reflect.String:
+		ok = enc == EncodingString
+	case reflect.Slice, reflect.Array:
+		ok = enc == EncodingString && t.Elem().Kind() == reflect.Uint8
+	}
+	if !ok {
+		return EncodingDefault, fmt.Errorf("encoding option of field %s does not apply to %s", field.Name, field.Type)
+	}
+	return enc, nil
+}
+
 // ProtoField contains cached reflected metadata for struct fields.
 type ProtoField struct {
 	ID     int64
@@ -47,35 +122,131 @@ type ProtoField struct {
 	Name   string // If non-empty, tag-defined field name.
 	Index  []int
 	Field  reflect.StructField
+
+	// Unpacked is set by the "packed=false" tag option. It makes Encode
+	// write each element of a repeated scalar field with its own key,
+	// for peers that don't accept the packed form.
+	Unpacked bool
+
+	// Encoding is set by an encoding tag option such as "fixed".
+	Encoding TagEncoding
+
+	// WellKnown is set by the "wkt" tag option. It sends time.Time
+	// and time.Duration values, dynamic values such as
+	// map[string]interface{}, and optional scalars such as *string
+	// as the equivalent well-known types,
+	// as EncodeOptions.WellKnownTypes does for all fields.
+	WellKnown bool
+}
+
+// hasTagOption reports whether the protobuf tag of a struct field
+// includes option, such as "oneof" or "packed=false".
+func hasTagOption(field reflect.StructField, option string) bool {
+	for _, part := range strings.Split(field.Tag.Get("protobuf"), ",") {
+		if part == option {
+			return true
+		}
+	}
+	return false
 }
 
 func (p *ProtoField) Required() bool {
 	return p.Prefix == TagRequired || p.Field.Type.Kind() != reflect.Ptr
 }
 
-var cache = map[reflect.Type][]*ProtoField{}
+// UnknownFields collects the raw encoding of fields that a message
+// doesn't declare. A struct may have one exported field of this type,
+// which doesn't take up a field number. Decode appends every
+// unrecognised field it encounters to it, key and all, and Encode writes
+// its content back out after the declared fields. This allows a program
+// built against an older version of a message to pass on fields added
+// by newer peers without losing them.
+type UnknownFields []byte
+
+var unknownFieldsType = reflect.TypeOf(UnknownFields(nil))
+
+// Proto3Message may be implemented by struct types to choose
+// proto3 semantics for their fields when Proto3 returns true,
+// regardless of EncodeOptions.Proto3. See EncodeOptions.Proto3 for details.
+type Proto3Message interface {
+	Proto3() bool
+}
+
+// typeInfo holds the cached reflected metadata for a struct type.
+type typeInfo struct {
+	fields  []*ProtoField
+	byID    map[int64]*ProtoField
+	unknown []int         // Index of the UnknownFields field, if any
+	oneofs  []*ProtoField // Fields tagged "oneof", which have no ID
+	proto3  bool          // Whether the type implements Proto3Message
+}
+
+var cache = map[reflect.Type]*typeInfo{}
 var cacheLock sync.Mutex
 
-func ProtoFields(t reflect.Type) []*ProtoField {
+func getTypeInfo(t reflect.Type) *typeInfo {
 	cacheLock.Lock()
-	idx, ok := cache[t]
+	info, ok := cache[t]
 	cacheLock.Unlock()
 	if ok {
-		return idx
+		return info
 	}
 	id := 0
-	idx = innerFieldIndexes(&id, t)
-	seen := map[int64]struct{}{}
-	for _, i := range idx {
-		if _, ok := seen[i.ID]; ok {
+	info = &typeInfo{
+		fields: innerFieldIndexes(&id, t),
+		byID:   map[int64]*ProtoField{},
+	}
+	st := typeIndirect(t)
+	if m, ok := reflect.New(st).Interface().(Proto3Message); ok {
+		info.proto3 = m.Proto3()
+	}
+	for i := 0; i < st.NumField(); i++ {
+		if f := st.Field(i); f.Type == unknownFieldsType {
+			if f.PkgPath != "" {
+				panic(fmt.Sprintf("UnknownFields field %s.%s must be exported", t.Name(), f.Name))
+			}
+			if info.unknown != nil {
+				panic(fmt.Sprintf("more than one UnknownFields field in %s.%s", t.PkgPath(), t.Name()))
+			}
+			info.unknown = []int{i}
+		}
+		if f := st.Field(i); hasTagOption(f, "oneof") && f.PkgPath == "" {
+			if f.Type.Kind() != reflect.Interface {
+				panic(fmt.Sprintf("oneof field %s.%s is not an interface", t.Name(), f.Name))
+			}
+			_, prefix, name := ParseTag(f)
+			info.oneofs = append(info.oneofs, &ProtoField{
+				Prefix: prefix,
+				Name:   name,
+				Index:  []int{i},
+				Field:  f,
+			})
+		}
+	}
+	for _, i := range info.fields {
+		if _, ok := info.byID[i.ID]; ok {
 			panic(fmt.Sprintf("protobuf ID %d reused in %s.%s", i.ID, t.PkgPath(), t.Name()))
 		}
-		seen[i.ID] = struct{}{}
+		info.byID[i.ID] = i
+	}
+	for _, f := range info.oneofs {
+		if group := getOneofGroup(f.Field.Type); group != nil {
+			for id := range group.byID {
+				if _, ok := info.byID[id]; ok {
+					panic(fmt.Sprintf("protobuf ID %d reused in %s.%s", id, t.PkgPath(), t.Name()))
+				}
+			}
+		}
 	}
 	cacheLock.Lock()
 	defer cacheLock.Unlock()
-	cache[t] = idx
-	return idx
+	cache[t] = info
+	return info
+}
+
+// ProtoFields returns the fields of struct type t in declaration order.
+func ProtoFields(t reflect.Type) []*ProtoField {
+	return getTypeInfo(t).fields
 }
 
 func innerFieldIndexes(id *int, v reflect.Type) []*ProtoField {
@@ -85,6 +256,9 @@ func innerFieldIndexes(id *int, v reflect.Type) []*ProtoField {
 	out := []*ProtoField{}
 	for i := 0; i < v.NumField(); i++ {
 		f := v.Field(i)
+		if f.Type == unknownFieldsType || hasTagOption(f, "oneof") {
+			continue
+		}
 		*id++
 		tid, prefix, name := ParseTag(f)
 		if tid != 0 {
@@ -97,12 +271,22 @@ func innerFieldIndexes(id *int, v reflect.Type) []*ProtoField {
 				out = append(out, inner)
 			}
 		} else {
+			enc, err := ParseTagEncoding(f)
+			if err == nil {
+				err = checkWellKnown(f, enc)
+			}
+			if err != nil {
+				panic(err.Error())
+			}
 			out = append(out, &ProtoField{
-				ID:     int64(*id),
-				Prefix: prefix,
-				Name:   name,
-				Index:  []int{i},
-				Field:  f,
+				ID:        int64(*id),
+				Prefix:    prefix,
+				Name:      name,
+				Index:     []int{i},
+				Field:     f,
+				Unpacked:  hasTagOption(f, "packed=false"),
+				Encoding:  enc,
+				WellKnown: hasTagOption(f, "wkt"),
 			})
 		}
 	}
@@ -33,10 +33,10 @@ func TestEncodeNested(t *testing.T) {
 		f.Field = reflect.StructField{}
 	}
 	expected := []*ProtoField{
-		{1, TagNone, "", []int{0}, reflect.StructField{}},
-		{2, TagNone, "", []int{1, 0}, reflect.StructField{}},
-		{10, TagNone, "", []int{1, 1}, reflect.StructField{}},
-		{11, TagNone, "renamed", []int{1, 2}, reflect.StructField{}},
+		{1, TagNone, "", []int{0}, reflect.StructField{}, false, EncodingDefault, false},
+		{2, TagNone, "", []int{1, 0}, reflect.StructField{}, false, EncodingDefault, false},
+		{10, TagNone, "", []int{1, 1}, reflect.StructField{}, false, EncodingDefault, false},
+		{11, TagNone, "renamed", []int{1, 2}, reflect.StructField{}, false, EncodingDefault, false},
 	}
 	assert.Equal(t, expected, actual)
 	assert.Equal(t, v.FieldByIndex(actual[0].Index).Int(), int64(13))
@@ -11,6 +11,7 @@
 package protobuf
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 )
@@ -28,14 +29,34 @@ type t3 struct {
 	B bool
 }
 
+type t4 struct {
+	Next *t4
+	M    map[uint32]*t3
+	L    []uint64
+	S    []string
+	T    []t3
+}
+
+// fuzzLimits are deliberately tight, so that the fuzzer reaches them.
+var fuzzLimits = DecodeOptions{
+	MaxDepth:            8,
+	MaxMessageSize:      1 << 12,
+	MaxRepeatedElements: 16,
+	MaxMapEntries:       16,
+}
+
 func Fuzz(data []byte) int {
+	return fuzzRoundTrip(data) + fuzzLimited(data)
+}
+
+func fuzzRoundTrip(data []byte) int {
 	var it1, it2 t2
 	var err error
 	if err = Decode(data, &it1); err != nil {
 		return 0
 	}
 	var buf []byte
-	if buf, err = Encode(it1); err != nil {
+	if buf, err = Encode(&it1); err != nil {
 		return 0
 	}
 	if err = Decode(buf, &it2); err != nil {
@@ -46,3 +67,33 @@ func Fuzz(data []byte) int {
 	}
 	return 1
 }
+
+// fuzzLimited decodes data with resource limits in place. Every failure
+// must be reported as a DecodeError, and anything that decodes within
+// the limits must round trip within them too, except MaxMessageSize:
+// proto2 encoding writes zero fields that needn't be on the wire,
+// so the encoding may grow.
+func fuzzLimited(data []byte) int {
+	var it1, it2 t4
+	err := DecodeWithOptions(data, &it1, fuzzLimits)
+	if err != nil {
+		var de *DecodeError
+		if !errors.As(err, &de) {
+			panic(fmt.Sprintf("decode failed without a DecodeError: %v", err))
+		}
+		return 0
+	}
+	buf, err := Encode(&it1)
+	if err != nil {
+		return 0
+	}
+	limits := fuzzLimits
+	limits.MaxMessageSize = 0
+	if err = DecodeWithOptions(buf, &it2, limits); err != nil {
+		panic(fmt.Sprintf("round trip exceeds limits: %v", err))
+	}
+	if !reflect.DeepEqual(it1, it2) {
+		panic(fmt.Sprintf("round trip not equal %#v %#v", it1, it2))
+	}
+	return 1
+}
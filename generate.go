@@ -1,6 +1,8 @@
 package protobuf
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"reflect"
@@ -8,9 +10,12 @@ import (
 	"sort"
 	"strings"
 	"text/template"
-	"errors"
 )
 
+const protoHeaderTemplate = `[[if .Proto3]]syntax = "proto3";
+[[end]][[range .Imports]]import "[[.]]";
+[[end]]`
+
 const protoTemplate = `[[range $name, $values := .Enums]]
 enum [[$name|$.Renamer.TypeName]] {[[range $values]]
   [[.Name|$.Renamer.ConstName]] = [[.Value]];[[end]]
@@ -18,7 +23,10 @@ enum [[$name|$.Renamer.TypeName]] {[[range $values]]
 
 [[end]][[range .Types]]
 message [[.Name|$.Renamer.TypeName]] {[[range .|Fields]]
-  [[.|TypeName]] [[.|$.Renamer.FieldName]] = [[.ID]][[.|Options]];[[end]]
+  [[.|TypeName]] [[.|$.Renamer.FieldName]] = [[.ID]][[.|Options]];[[end]][[range .|Oneofs]]
+  oneof [[.Field|$.Renamer.FieldName]] {[[range .Members]]
+    [[.|OneofTypeName]] [[.|$.Renamer.FieldName]] = [[.ID]];[[end]]
+  }[[end]]
 }
 [[end]]
 `
@@ -32,7 +40,19 @@ func typeIndirect(t reflect.Type) reflect.Type {
 	return t
 }
 
-func typeName(f ProtoField, enums enumTypeMap, renamer GeneratorNamer) (s string) {
+// generator holds the state of a GenerateProtobufDefinition call.
+type generator struct {
+	enums   enumTypeMap
+	renamer GeneratorNamer
+	proto3  bool
+	codecs  *Codecs
+
+	wellKnown     bool            // Whether all fields use well-known types
+	anyInterfaces bool            // Whether interfaces are sent as Any
+	imports       map[string]bool // Files defining the well-known types used
+}
+
+func (g *generator) typeName(f ProtoField) (s string) {
 	defer func() {
 		if e := recover(); e != nil {
 			s = ""
@@ -40,19 +60,71 @@ func typeName(f ProtoField, enums enumTypeMap, renamer GeneratorNamer) (s string
 		}
 	}()
 	t := f.Field.Type
+	if (g.wellKnown || f.WellKnown) && dynamicTypeName(t) != "" {
+		// Dynamic values are messages, which may always be absent.
+		return g.fieldPrefix(f, TagOptional) + g.innerTypeName(t, true)
+	}
 	if t.Kind() == reflect.Slice {
 		if t.Elem().Kind() == reflect.Uint8 {
-			return fieldPrefix(f, TagNone) + "bytes"
+			return g.fieldPrefix(f, TagNone) + g.encodedTypeName(t, f)
+		}
+		if t.Elem() == durationType && f.Encoding == EncodingDefault {
+			return "repeated " + g.innerTypeName(durationType, durationMessages(&f))
 		}
-		return "repeated " + innerTypeName(typeIndirect(t.Elem()), enums, renamer)
+		return "repeated " + g.encodedTypeName(typeIndirect(t.Elem()), f)
 	}
 	if t.Kind() == reflect.Ptr {
-		return fieldPrefix(f, TagOptional) + innerTypeName(t.Elem(), enums, renamer)
+		if name := g.wrapperTypeName(f); name != "" {
+			return g.fieldPrefix(f, TagOptional) + name
+		}
+		return g.fieldPrefix(f, TagOptional) + g.encodedTypeName(t.Elem(), f)
 	}
-	return fieldPrefix(f, TagNone) + innerTypeName(t, enums, renamer)
+	return g.fieldPrefix(f, TagNone) + g.encodedTypeName(t, f)
 }
 
-func fieldPrefix(f ProtoField, def TagPrefix) string {
+// encodedTypeName is like innerTypeName,
+// but honours the tag options of field f.
+func (g *generator) encodedTypeName(t reflect.Type, f ProtoField) string {
+	enc := f.Encoding
+	if enc == EncodingDefault {
+		return g.innerTypeName(t, g.wellKnown || f.WellKnown)
+	}
+	if enc == EncodingString {
+		return "string"
+	}
+	bits := "64"
+	if t.Size() <= 4 {
+		bits = "32"
+	}
+	signed := false
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		signed = true
+	}
+	switch {
+	case enc == EncodingFixed && signed:
+		return "sfixed" + bits
+	case enc == EncodingFixed:
+		return "fixed" + bits
+	case enc == EncodingZigzag:
+		return "sint" + bits
+	case signed:
+		return "int" + bits
+	default:
+		return "uint" + bits
+	}
+}
+
+func (g *generator) fieldPrefix(f ProtoField, def TagPrefix) string {
+	if g.proto3 {
+		// Only fields with explicit presence have a label in proto3.
+		// Pointer fields have it, since Encode sends pointers to zero,
+		// so they keep the optional label.
+		if f.Field.Type.Kind() == reflect.Ptr {
+			return "optional "
+		}
+		return ""
+	}
 	opt := def
 	if def == TagNone {
 		opt = f.Prefix
@@ -70,10 +142,31 @@ func fieldPrefix(f ProtoField, def TagPrefix) string {
 	}
 }
 
-func innerTypeName(t reflect.Type, enums enumTypeMap, renamer GeneratorNamer) string {
+// innerTypeName returns the .proto type of values of type t,
+// using well-known types where there are any if wellKnown is set.
+func (g *generator) innerTypeName(t reflect.Type, wellKnown bool) string {
+	if name := g.codecTypeName(t); name != "" {
+		return name
+	}
 	if (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() == reflect.Uint8 {
 		return "bytes"
 	}
+	if wellKnown && t == timeType {
+		g.imports[timestampProto] = true
+		return "google.protobuf.Timestamp"
+	}
+	if wellKnown && t == durationType {
+		g.imports[durationProto] = true
+		return "google.protobuf.Duration"
+	}
+	if name := dynamicTypeName(t); wellKnown && name != "" {
+		g.imports[structProto] = true
+		return name
+	}
+	if t == anyType || t.Kind() == reflect.Interface && g.anyInterfaces {
+		g.imports[anyProto] = true
+		return "google.protobuf.Any"
+	}
 	if t.PkgPath() == "time" {
 		if t.Name() == "Time" {
 			return "sfixed64"
@@ -83,6 +176,10 @@ func innerTypeName(t reflect.Type, enums enumTypeMap, renamer GeneratorNamer) st
 		}
 	}
 	switch t.Name() {
+	case "Int32":
+		return "int32"
+	case "Int64":
+		return "int64"
 	case "Ufixed32":
 		return "fixed32"
 	case "Ufixed64":
@@ -93,8 +190,8 @@ func innerTypeName(t reflect.Type, enums enumTypeMap, renamer GeneratorNamer) st
 		return "sfixed64"
 	}
 
-	if _, ok := enums[t.Name()]; ok {
-		return renamer.TypeName(t.Name())
+	if _, ok := g.enums[t.Name()]; ok {
+		return g.renamer.TypeName(t.Name())
 	}
 
 	switch t.Kind() {
@@ -102,15 +199,15 @@ func innerTypeName(t reflect.Type, enums enumTypeMap, renamer GeneratorNamer) st
 		return "double"
 	case reflect.Float32:
 		return "float"
-	case reflect.Int32:
+	case reflect.Int8, reflect.Int16, reflect.Int32:
 		return "sint32"
 	case reflect.Int, reflect.Int64:
 		return "sint64"
 	case reflect.Bool:
 		return "bool"
-	case reflect.Uint32:
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
 		return "uint32"
-	case reflect.Uint, reflect.Uint64:
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
 		return "uint64"
 	case reflect.String:
 		return "string"
@@ -124,29 +221,54 @@ func innerTypeName(t reflect.Type, enums enumTypeMap, renamer GeneratorNamer) st
 			if valType.Elem().Kind() == reflect.Uint8 {
 				valTypeName = "bytes"
 			} else {
-				valTypeName = innerTypeName(typeIndirect(valType.Elem()), enums, renamer)
+				valTypeName = g.innerTypeName(typeIndirect(valType.Elem()), wellKnown)
 			}
 		} else if valType.Kind() == reflect.Ptr {
-			valTypeName = innerTypeName(valType.Elem(), enums, renamer)
+			valTypeName = g.innerTypeName(valType.Elem(), wellKnown)
 		} else {
 			// here we can just use the value's type:
-			valTypeName = innerTypeName(valType, enums, renamer)
+			valTypeName = g.innerTypeName(valType, wellKnown)
 		}
-		return fmt.Sprintf("map<%s, %s>", innerTypeName(t.Key(), enums, renamer), valTypeName)
+		return fmt.Sprintf("map<%s, %s>", g.innerTypeName(t.Key(), wellKnown), valTypeName)
 	default:
 		panic("unsupported type " + t.Name())
 	}
 }
 
-func options(f ProtoField) string {
-	if f.Field.Type.Kind() == reflect.Slice {
-		switch f.Field.Type.Elem().Kind() {
-		case reflect.Bool,
-			reflect.Int32, reflect.Int64,
-			reflect.Uint32, reflect.Uint64,
-			reflect.Float32, reflect.Float64:
-			return " [packed=true]"
-		}
+// oneofTypeName returns the type of a oneof member,
+// which has no label even if it is a pointer.
+func (g *generator) oneofTypeName(f ProtoField) string {
+	if name := g.wrapperTypeName(f); name != "" {
+		return name
+	}
+	return g.encodedTypeName(typeIndirect(f.Field.Type), f)
+}
+
+// wrapperTypeName returns the wrapper type declaring
+// the optional scalar field f, or "" if f isn't wrapped.
+func (g *generator) wrapperTypeName(f ProtoField) string {
+	t := f.Field.Type
+	name := wrapperName(t)
+	if name == "" || !g.wellKnown && !f.WellKnown || f.Encoding != EncodingDefault ||
+		g.codecTypeName(t.Elem()) != "" {
+		return ""
+	}
+	g.imports[wrappersProto] = true
+	return name
+}
+
+func (g *generator) options(f ProtoField) string {
+	if f.Field.Type.Kind() != reflect.Slice || !packable(f.Field.Type.Elem()) ||
+		g.codecs.Lookup(f.Field.Type.Elem()) != nil ||
+		f.Field.Type.Elem() == durationType && durationMessages(&f) {
+		return ""
+	}
+	// Repeated scalars are packed by default in proto3 only.
+	switch {
+	case f.Unpacked && g.proto3:
+		return " [packed=false]"
+	case !f.Unpacked && !g.proto3:
+		return " [packed=true]"
 	}
 	return ""
 }
@@ -209,9 +331,51 @@ func (e enumValues) Less(i, j int) bool { return e[i].Value < e[j].Value }
 
 type enumTypeMap map[string]enumValues
 
+// GenerateOptions control how GenerateWithOptions writes .proto files.
+type GenerateOptions struct {
+	// Proto3 always generates a proto3 file, matching EncodeOptions.Proto3.
+	Proto3 bool
+
+	// WellKnownTypes declares time.Time, time.Duration, dynamic fields
+	// and optional scalars as the equivalent well-known types,
+	// such as google.protobuf.Timestamp or StringValue,
+	// matching EncodeOptions.WellKnownTypes, and imports them.
+	// Fields with the "wkt" tag option are declared so regardless,
+	// and repeated time.Duration fields only if they have it.
+	WellKnownTypes bool
+
+	// AnyInterfaces declares interface fields as google.protobuf.Any,
+	// matching EncodeOptions.AnyInterfaces, and imports it.
+	AnyInterfaces bool
+
+	// Codecs give the types of the fields they are registered for,
+	// or DefaultCodecs if nil. They should match the codecs
+	// used to encode the messages.
+	Codecs *Codecs
+}
+
 // GenerateProtobufDefinition generates a .proto file from a list of structs via reflection.
 // fieldNamer is a function that maps ProtoField types to generated protobuf field names.
+// The file uses proto3 syntax if all the struct types implement Proto3Message,
+// and proto2 syntax otherwise.
+//
+// Proto3 files have no required labels. Pointer fields are still
+// declared optional, because Encode sends them even if they point to zero,
+// unlike other proto3 fields. Such proto3 optional fields need protoc 3.15
+// or later, or 3.12 with the --experimental_allow_proto3_optional flag.
 func GenerateProtobufDefinition(w io.Writer, types []interface{}, enumMap EnumMap, renamer GeneratorNamer) (err error) {
+	return GenerateWithOptions(w, types, enumMap, renamer, GenerateOptions{})
+}
+
+// GenerateProto3Definition is like GenerateProtobufDefinition,
+// but always generates a proto3 file, matching EncodeOptions.Proto3.
+func GenerateProto3Definition(w io.Writer, types []interface{}, enumMap EnumMap, renamer GeneratorNamer) (err error) {
+	return GenerateWithOptions(w, types, enumMap, renamer, GenerateOptions{Proto3: true})
+}
+
+// GenerateWithOptions is like GenerateProtobufDefinition,
+// but the generation is controlled by opts.
+func GenerateWithOptions(w io.Writer, types []interface{}, enumMap EnumMap, renamer GeneratorNamer, opts GenerateOptions) (err error) {
 	defer func() {
 		if e := recover(); e != nil {
 			err = errors.New(e.(string))
@@ -241,15 +405,41 @@ func GenerateProtobufDefinition(w io.Writer, types []interface{}, enumMap EnumMa
 		rt = append(rt, typ)
 	}
 	sort.Sort(rt)
+	proto3 := opts.Proto3
+	if !proto3 && len(rt) > 0 {
+		proto3 = getTypeInfo(rt[0]).proto3
+		for _, t := range rt[1:] {
+			if getTypeInfo(t).proto3 != proto3 {
+				return errors.New("cannot mix proto2 and proto3 message types in one file")
+			}
+		}
+	}
 	if renamer == nil {
 		renamer = &DefaultGeneratorNamer{}
 	}
+	codecs := opts.Codecs
+	if codecs == nil {
+		codecs = DefaultCodecs
+	}
+	g := &generator{
+		enums:         enums,
+		renamer:       renamer,
+		proto3:        proto3,
+		codecs:        codecs,
+		wellKnown:     opts.WellKnownTypes,
+		anyInterfaces: opts.AnyInterfaces,
+		imports:       map[string]bool{},
+	}
 	t := template.Must(template.New("protobuf").Funcs(template.FuncMap{
-		"Fields":   ProtoFields,
-		"TypeName": func(f ProtoField) string { return typeName(f, enums, renamer) },
-		"Options":  options,
+		"Fields":        ProtoFields,
+		"Oneofs":        oneofs,
+		"TypeName":      g.typeName,
+		"OneofTypeName": g.oneofTypeName,
+		"Options":       g.options,
 	}).Delims("[[", "]]").Parse(protoTemplate))
-	return t.Execute(w, map[string]interface{}{
+	// The imports are known once the messages have been generated.
+	body := &bytes.Buffer{}
+	err = t.Execute(body, map[string]interface{}{
 		"Renamer": renamer,
 		"Enums":   enums,
 		"Types":   rt,
@@ -257,4 +447,22 @@ func GenerateProtobufDefinition(w io.Writer, types []interface{}, enumMap EnumMa
 		"Slice":   reflect.Slice,
 		"Map":     reflect.Map,
 	})
+	if err != nil {
+		return err
+	}
+	imports := make([]string, 0, len(g.imports))
+	for file := range g.imports {
+		imports = append(imports, file)
+	}
+	sort.Strings(imports)
+	header := template.Must(template.New("header").Delims("[[", "]]").Parse(protoHeaderTemplate))
+	err = header.Execute(w, map[string]interface{}{
+		"Proto3":  proto3,
+		"Imports": imports,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = body.WriteTo(w)
+	return err
 }
@@ -0,0 +1,72 @@
+package protobuf
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type twosComplement struct {
+	I32  Int32
+	I64  Int64
+	OI32 *Int32
+	SI32 []Int32
+	SI64 []Int64
+}
+
+func TestInt32Encoding(t *testing.T) {
+	buf, err := Encode(&twosComplement{I32: -1, I64: 150})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01,
+		0x10, 0x96, 0x01,
+		0x22, 0x00, 0x2a, 0x00, // empty repeated fields
+	}, buf)
+}
+
+func TestInt32RoundTrip(t *testing.T) {
+	o := Int32(-3)
+	in := twosComplement{
+		I32:  -2147483648,
+		I64:  -9223372036854775808,
+		OI32: &o,
+		SI32: []Int32{-1, 0, 2147483647},
+		SI64: []Int64{-5, 5},
+	}
+	buf, err := Encode(&in)
+	require.NoError(t, err)
+	var out twosComplement
+	require.NoError(t, Decode(buf, &out))
+	assert.Equal(t, in, out)
+}
+
+func TestInt32OutOfRange(t *testing.T) {
+	// 2^31 doesn't fit in an int32.
+	buf := []byte{0x08, 0x80, 0x80, 0x80, 0x80, 0x08}
+	var out twosComplement
+	err := Decode(buf, &out)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "out of range")
+
+	// Wire types other than varint are rejected.
+	assert.Error(t, Decode([]byte{0x0d, 0, 0, 0, 0}, &out))
+}
+
+func TestGenerateInt32(t *testing.T) {
+	w := &bytes.Buffer{}
+	err := GenerateProtobufDefinition(w, []interface{}{twosComplement{}}, nil, nil)
+	require.NoError(t, err)
+	expected := `
+message twosComplement {
+  required int32 i32 = 1;
+  required int64 i64 = 2;
+  optional int32 oi32 = 3;
+  repeated int32 si32 = 4 [packed=true];
+  repeated int64 si64 = 5 [packed=true];
+}
+
+`
+	assert.Equal(t, expected, w.String())
+}
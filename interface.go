@@ -1,10 +1,19 @@
 package protobuf
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 )
 
-var generators = newInterfaceRegistry()
+// DefaultRegistry is the registry used by RegisterInterface,
+// and by encoding and decoding when the options don't name another one.
+var DefaultRegistry = NewRegistry()
 
 // InterfaceMarshaler is used to differentiate implementations of an
 // interface when encoding/decoding
@@ -20,35 +29,225 @@ type InterfaceGeneratorFunc func() interface{}
 // GeneratorID is the key used to map the generator functions
 type GeneratorID [8]byte
 
-type generatorRegistry struct {
-	generators map[GeneratorID]InterfaceGeneratorFunc
+// NameGeneratorID derives a GeneratorID from name,
+// as the first 8 bytes of its SHA-256 hash.
+func NameGeneratorID(name string) GeneratorID {
+	var id GeneratorID
+	h := sha256.Sum256([]byte(name))
+	copy(id[:], h[:])
+	return id
 }
 
-func newInterfaceRegistry() *generatorRegistry {
-	return &generatorRegistry{
-		generators: make(map[GeneratorID]InterfaceGeneratorFunc),
+// TypeGeneratorID derives a GeneratorID from the fully-qualified
+// name of type t, such as "*go.dedis.ch/kyber/v3/group/edwards25519.point".
+func TypeGeneratorID(t reflect.Type) GeneratorID {
+	return NameGeneratorID(qualifiedName(t))
+}
+
+func qualifiedName(t reflect.Type) string {
+	var ptrs strings.Builder
+	for t.Kind() == reflect.Ptr {
+		ptrs.WriteString("*")
+		t = t.Elem()
+	}
+	if t.Name() == "" || t.PkgPath() == "" {
+		return ptrs.String() + t.String()
+	}
+	return ptrs.String() + t.PkgPath() + "." + t.Name()
+}
+
+// Registry maps GeneratorIDs to the functions generating
+// the implementations of interfaces they identify.
+// Interface fields holding a value of a registered type,
+// or an InterfaceMarshaler with a registered ID, are encoded along with
+// the ID, so that decoding can instantiate the right type.
+// Separate registries let subsystems, or tests, reuse IDs without conflict.
+// A Registry is safe for concurrent use.
+type Registry struct {
+	lock       sync.RWMutex
+	generators map[GeneratorID]registryEntry
+	ids        map[reflect.Type]GeneratorID
+}
+
+type registryEntry struct {
+	gen  InterfaceGeneratorFunc
+	typ  reflect.Type // Type of the values gen returns
+	name string       // Name the ID is derived from, if any
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		generators: make(map[GeneratorID]registryEntry),
+		ids:        make(map[reflect.Type]GeneratorID),
 	}
 }
 
-// register gets the type tag and map it to the generator function
-func (ir *generatorRegistry) register(g InterfaceGeneratorFunc) {
-	val, ok := g().(InterfaceMarshaler)
+// Register maps the ID of the InterfaceMarshaler generated by g to g.
+// It returns an error if the generated value isn't an InterfaceMarshaler,
+// or under the same conditions as RegisterWithID.
+func (r *Registry) Register(g InterfaceGeneratorFunc) error {
+	v := g()
+	val, ok := v.(InterfaceMarshaler)
 	if !ok {
-		panic("Implementation of the interface must fulfilled InterfaceMarshaler")
+		return fmt.Errorf("protobuf: %T does not implement InterfaceMarshaler", v)
 	}
-	key := val.MarshalID()
+	return r.register(val.MarshalID(), "", g, v)
+}
 
-	ir.generators[key] = g
+// RegisterName maps the ID derived from name by NameGeneratorID to g,
+// and returns that ID. The generated type need not implement MarshalID.
+// It returns an error under the same conditions as RegisterWithID.
+func (r *Registry) RegisterName(name string, g InterfaceGeneratorFunc) (GeneratorID, error) {
+	id := NameGeneratorID(name)
+	return id, r.register(id, name, g, g())
 }
 
-// get returns the generator associated with the tag
-func (ir *generatorRegistry) get(key GeneratorID) InterfaceGeneratorFunc {
-	g, _ := ir.generators[key]
-	return g
+// RegisterDerived maps the ID derived by TypeGeneratorID
+// from the type generated by g to g, and returns that ID.
+// The generated type need not implement MarshalID.
+// It returns an error under the same conditions as RegisterWithID.
+func (r *Registry) RegisterDerived(g InterfaceGeneratorFunc) (GeneratorID, error) {
+	v := g()
+	name := qualifiedName(reflect.TypeOf(v))
+	id := NameGeneratorID(name)
+	return id, r.register(id, name, g, v)
+}
+
+// RegisterWithID maps id to g. It returns an error if id is already
+// registered for another type, or the generated type for another ID.
+// Registering the same type with the same ID again replaces the generator.
+// Values of the generated type written to interface fields
+// are then tagged with id, whether or not they implement MarshalID.
+func (r *Registry) RegisterWithID(id GeneratorID, g InterfaceGeneratorFunc) error {
+	return r.register(id, "", g, g())
+}
+
+func (r *Registry) register(id GeneratorID, name string, g InterfaceGeneratorFunc, v interface{}) error {
+	typ := reflect.TypeOf(v)
+	if typ == nil {
+		return fmt.Errorf("protobuf: generator for ID %x returns nil", id[:])
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if e, ok := r.generators[id]; ok && e.typ != typ {
+		return fmt.Errorf("protobuf: generator ID %x of %s is already registered for %s",
+			id[:], typ, e.typ)
+	}
+	if old, ok := r.ids[typ]; ok && old != id {
+		return fmt.Errorf("protobuf: %s is already registered with generator ID %x",
+			typ, old[:])
+	}
+	r.generators[id] = registryEntry{gen: g, typ: typ, name: name}
+	r.ids[typ] = id
+	return nil
+}
+
+// Lookup returns the generator registered for id, or nil if there is none.
+func (r *Registry) Lookup(id GeneratorID) InterfaceGeneratorFunc {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.generators[id].gen
+}
+
+// idOf returns the ID to tag v with in an interface field,
+// and whether there is one.
+func (r *Registry) idOf(v interface{}) (GeneratorID, bool) {
+	r.lock.RLock()
+	id, ok := r.ids[reflect.TypeOf(v)]
+	r.lock.RUnlock()
+	if ok {
+		return id, true
+	}
+	// Any type with the ID of a registered generator is tagged.
+	if im, ok := v.(InterfaceMarshaler); ok {
+		id = im.MarshalID()
+		return id, r.Lookup(id) != nil
+	}
+	return id, false
+}
+
+// RegisterType registers the type of value, a struct or a pointer to one,
+// under the ID derived from name by NameGeneratorID, and returns that ID.
+// Like gob.RegisterName, this lets interface fields hold values
+// of the type without any methods for encoding: Encode writes them
+// reflectively after their ID, and Decode rebuilds them.
+// It returns an error under the same conditions as RegisterWithID.
+func (r *Registry) RegisterType(name string, value interface{}) (GeneratorID, error) {
+	t := reflect.TypeOf(value)
+	if t == nil || typeIndirect(t).Kind() != reflect.Struct {
+		return GeneratorID{}, fmt.Errorf("protobuf: cannot register %v, which is not a struct", t)
+	}
+	g := func() interface{} {
+		if t.Kind() == reflect.Ptr {
+			return reflect.New(t.Elem()).Interface()
+		}
+		return reflect.Zero(t).Interface()
+	}
+	id := NameGeneratorID(name)
+	return id, r.register(id, name, g, value)
+}
+
+// IDs returns the registered IDs in increasing order.
+func (r *Registry) IDs() []GeneratorID {
+	r.lock.RLock()
+	ids := make([]GeneratorID, 0, len(r.generators))
+	for id := range r.generators {
+		ids = append(ids, id)
+	}
+	r.lock.RUnlock()
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(ids[i][:], ids[j][:]) < 0
+	})
+	return ids
+}
+
+// Unregister removes the generator registered for id, if any.
+func (r *Registry) Unregister(id GeneratorID) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if e, ok := r.generators[id]; ok {
+		delete(r.ids, e.typ)
+		delete(r.generators, id)
+	}
 }
 
 // RegisterInterface registers the generator to be used to decode
-// the type generated by the function
+// the type generated by the function in DefaultRegistry.
+// It panics if Register returns an error.
 func RegisterInterface(f InterfaceGeneratorFunc) {
-	generators.register(f)
+	if err := DefaultRegistry.Register(f); err != nil {
+		panic(err.Error())
+	}
+}
+
+// RegisterType is like RegisterInterface,
+// but uses DefaultRegistry.RegisterType.
+func RegisterType(name string, value interface{}) GeneratorID {
+	id, err := DefaultRegistry.RegisterType(name, value)
+	if err != nil {
+		panic(err.Error())
+	}
+	return id
+}
+
+// RegisterInterfaceName is like RegisterInterface,
+// but uses DefaultRegistry.RegisterName.
+func RegisterInterfaceName(name string, f InterfaceGeneratorFunc) GeneratorID {
+	id, err := DefaultRegistry.RegisterName(name, f)
+	if err != nil {
+		panic(err.Error())
+	}
+	return id
+}
+
+// RegisterInterfaceDerived is like RegisterInterface,
+// but uses DefaultRegistry.RegisterDerived.
+func RegisterInterfaceDerived(f InterfaceGeneratorFunc) GeneratorID {
+	id, err := DefaultRegistry.RegisterDerived(f)
+	if err != nil {
+		panic(err.Error())
+	}
+	return id
 }
@@ -1,6 +1,7 @@
 package protobuf
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -39,12 +40,117 @@ func (b *testStructB) MarshalBinary() ([]byte, error) {
 }
 
 func TestInterfaceRegistry(t *testing.T) {
-	r := newInterfaceRegistry()
+	r := NewRegistry()
 
-	r.register(func() interface{} { return &testStructA{} })
-	r.register(func() interface{} { return &testStructB{} })
+	require.NoError(t, r.Register(func() interface{} { return &testStructA{} }))
+	require.NoError(t, r.Register(func() interface{} { return &testStructB{} }))
 
-	require.NotNil(t, r.get(GeneratorID{'a'}))
-	require.NotNil(t, r.get(GeneratorID{'b'}))
-	require.Nil(t, r.get(GeneratorID{'c'}))
+	require.NotNil(t, r.Lookup(GeneratorID{'a'}))
+	require.NotNil(t, r.Lookup(GeneratorID{'b'}))
+	require.Nil(t, r.Lookup(GeneratorID{'c'}))
+	require.Equal(t, []GeneratorID{{'a'}, {'b'}}, r.IDs())
+
+	r.Unregister(GeneratorID{'a'})
+	require.Nil(t, r.Lookup(GeneratorID{'a'}))
+	require.Equal(t, []GeneratorID{{'b'}}, r.IDs())
+}
+
+// testStructC reuses the ID of testStructA.
+type testStructC struct{ testStructA }
+
+func TestInterfaceRegistryConflict(t *testing.T) {
+	r := NewRegistry()
+	require.NoError(t, r.Register(func() interface{} { return &testStructA{} }))
+	// Registering the same type again is fine.
+	require.NoError(t, r.Register(func() interface{} { return &testStructA{} }))
+
+	err := r.Register(func() interface{} { return &testStructC{} })
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "already registered")
+	require.IsType(t, &testStructA{}, r.Lookup(GeneratorID{'a'})())
+
+	require.Error(t, r.Register(func() interface{} { return 42 }))
+}
+
+type testStructHolder struct {
+	I testInterface
+}
+
+func TestRegistryOptions(t *testing.T) {
+	r := NewRegistry()
+	require.NoError(t, r.Register(func() interface{} { return &testStructB{} }))
+
+	in := testStructHolder{I: &testStructB{}}
+	buf, err := EncodeWithOptions(&in, EncodeOptions{Registry: r})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x0a, 0x08, 'b', 0, 0, 0, 0, 0, 0, 0}, buf)
+
+	// Neither the default registry nor the constructors know testStructB.
+	var out testStructHolder
+	require.Error(t, Decode(buf, &out))
+
+	out = testStructHolder{}
+	require.NoError(t, DecodeWithOptions(buf, &out, DecodeOptions{Registry: r}))
+	require.Equal(t, "B", out.I.String())
+}
+
+// testStructD can marshal itself, but has no MarshalID method.
+type testStructD struct {
+	data []byte
+}
+
+func (d *testStructD) String() string {
+	return string(d.data)
+}
+
+func (d *testStructD) MarshalBinary() ([]byte, error) {
+	return d.data, nil
+}
+
+func (d *testStructD) UnmarshalBinary(data []byte) error {
+	d.data = append([]byte{}, data...)
+	return nil
+}
+
+func TestDerivedGeneratorIDs(t *testing.T) {
+	typ := reflect.TypeOf(&testStructD{})
+	require.Equal(t, NameGeneratorID("*go.dedis.ch/protobuf.testStructD"), TypeGeneratorID(typ))
+	require.NotEqual(t, NameGeneratorID("a"), NameGeneratorID("b"))
+
+	r := NewRegistry()
+	id, err := r.RegisterDerived(func() interface{} { return &testStructD{} })
+	require.NoError(t, err)
+	require.Equal(t, TypeGeneratorID(typ), id)
+	// Registering the type again is fine, but not under another ID.
+	_, err = r.RegisterDerived(func() interface{} { return &testStructD{} })
+	require.NoError(t, err)
+	_, err = r.RegisterName("d", func() interface{} { return &testStructD{} })
+	require.Error(t, err)
+
+	in := testStructHolder{I: &testStructD{[]byte("xyz")}}
+	buf, err := EncodeWithOptions(&in, EncodeOptions{Registry: r})
+	require.NoError(t, err)
+	require.Equal(t, append([]byte{0x0a, 11}, append(id[:], "xyz"...)...), buf)
+
+	var out testStructHolder
+	require.NoError(t, DecodeWithOptions(buf, &out, DecodeOptions{Registry: r}))
+	require.Equal(t, "xyz", out.I.String())
+}
+
+func TestNamedGeneratorIDs(t *testing.T) {
+	r := NewRegistry()
+	id, err := r.RegisterName("test.D", func() interface{} { return &testStructD{} })
+	require.NoError(t, err)
+	require.Equal(t, NameGeneratorID("test.D"), id)
+
+	// Another type can't take the same name.
+	_, err = r.RegisterName("test.D", func() interface{} { return &testStructB{} })
+	require.Error(t, err)
+
+	in := testStructHolder{I: &testStructD{[]byte("xyz")}}
+	buf, err := EncodeWithOptions(&in, EncodeOptions{Registry: r})
+	require.NoError(t, err)
+	var out testStructHolder
+	require.NoError(t, DecodeWithOptions(buf, &out, DecodeOptions{Registry: r}))
+	require.Equal(t, "xyz", out.I.String())
 }
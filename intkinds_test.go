@@ -0,0 +1,108 @@
+package protobuf
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type intKinds struct {
+	I   int
+	I8  int8
+	I16 int16
+	U   uint
+	U8  uint8
+	U16 uint16
+	Up  uintptr
+	SI8 []int8
+	SU  []uint16
+	F16 int16 `protobuf:"fixed"`
+	V8  *uint8
+}
+
+func TestIntKindsWireFormat(t *testing.T) {
+	in := intKinds{I8: -1, U: 1, U8: 255, SI8: []int8{1, -2}, F16: -1}
+	buf, err := Encode(&in)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x08, 0x00,
+		0x10, 0x01,
+		0x18, 0x00,
+		0x20, 0x01,
+		0x28, 0xff, 0x01,
+		0x30, 0x00,
+		0x38, 0x00,
+		0x42, 2, 0x02, 0x03,
+		0x4a, 0,
+		0x55, 0xff, 0xff, 0xff, 0xff,
+	}, buf)
+
+	var out intKinds
+	require.NoError(t, Decode(buf, &out))
+	assert.Equal(t, in, out)
+}
+
+func TestIntKindsRoundTrip(t *testing.T) {
+	v8 := uint8(7)
+	in := intKinds{
+		I:   math.MinInt64,
+		I8:  math.MinInt8,
+		I16: math.MaxInt16,
+		U:   math.MaxUint64,
+		U8:  math.MaxUint8,
+		U16: math.MaxUint16,
+		Up:  math.MaxUint32,
+		SI8: []int8{math.MaxInt8, math.MinInt8},
+		SU:  []uint16{0, math.MaxUint16},
+		F16: math.MinInt16,
+		V8:  &v8,
+	}
+	buf, err := Encode(&in)
+	require.NoError(t, err)
+	var out intKinds
+	require.NoError(t, Decode(buf, &out))
+	assert.Equal(t, in, out)
+}
+
+func TestIntKindsOutOfRange(t *testing.T) {
+	for _, buf := range [][]byte{
+		{0x10, 0x80, 0x02},             // 128 in an int8
+		{0x18, 0x80, 0x80, 0x04},       // -32769 in an int16
+		{0x28, 0x80, 0x02},             // 256 in a uint8
+		{0x4a, 3, 0x80, 0x80, 0x04},    // 65536 in a packed uint16
+		{0x55, 0x00, 0x80, 0x00, 0x00}, // 32768 in a fixed int16
+		{0x58, 0x80, 0x02},             // 256 in an optional uint8
+	} {
+		var out intKinds
+		err := Decode(buf, &out)
+		if assert.Error(t, err, "%x", buf) {
+			assert.Contains(t, err.Error(), "out of range")
+		}
+	}
+}
+
+func TestGenerateIntKinds(t *testing.T) {
+	w := &bytes.Buffer{}
+	err := GenerateProtobufDefinition(w, []interface{}{intKinds{}}, nil, nil)
+	require.NoError(t, err)
+	expected := `
+message intKinds {
+  required sint64 i = 1;
+  required sint32 i8 = 2;
+  required sint32 i16 = 3;
+  required uint64 u = 4;
+  required uint32 u8 = 5;
+  required uint32 u16 = 6;
+  required uint64 up = 7;
+  repeated sint32 si8 = 8 [packed=true];
+  repeated uint32 su = 9 [packed=true];
+  required sfixed32 f16 = 10;
+  optional uint32 v8 = 11;
+}
+
+`
+	assert.Equal(t, expected, w.String())
+}
@@ -0,0 +1,127 @@
+// Package kybercodec encodes kyber.Point and kyber.Scalar values
+// of a given group with the protobuf package.
+//
+// The codecs send points and scalars, and slices of them, as their
+// canonical binary marshaling, without the GeneratorID prefix that
+// interface fields otherwise carry, and declare them as bytes in .proto files.
+// Decoding instantiates them from the group, and rejects encodings
+// of the wrong length, encodings that aren't valid points or scalars,
+// and encodings that aren't the canonical ones of their value:
+//
+//	cs := protobuf.NewCodecs()
+//	kybercodec.Register(cs, suite)
+//	buf, err := protobuf.EncodeWithOptions(&msg, protobuf.EncodeOptions{Codecs: cs})
+//	err = protobuf.DecodeWithOptions(buf, &msg, protobuf.DecodeOptions{Codecs: cs})
+//
+// Constructors are provided as well, for messages written
+// with the default encoding of interface fields.
+package kybercodec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/protobuf"
+)
+
+var (
+	pointType  = reflect.TypeOf((*kyber.Point)(nil)).Elem()
+	scalarType = reflect.TypeOf((*kyber.Scalar)(nil)).Elem()
+)
+
+// Constructors returns constructors instantiating kyber.Point
+// and kyber.Scalar fields with points and scalars of g.
+func Constructors(g kyber.Group) protobuf.Constructors {
+	return protobuf.Constructors{
+		pointType:  func() interface{} { return g.Point() },
+		scalarType: func() interface{} { return g.Scalar() },
+	}
+}
+
+// Register adds the codecs for the points and scalars of g to cs,
+// for kyber.Point and kyber.Scalar fields and slices of them.
+func Register(cs *protobuf.Codecs, g kyber.Group) {
+	cs.Register(pointType, Point(g))
+	cs.Register(scalarType, Scalar(g))
+}
+
+// Point returns a codec for the points of g.
+func Point(g kyber.Group) protobuf.Codec {
+	return pointCodec{g}
+}
+
+type pointCodec struct {
+	g kyber.Group
+}
+
+func (c pointCodec) Encode(v interface{}) ([]byte, error) {
+	return v.(kyber.Point).MarshalBinary()
+}
+
+func (c pointCodec) Decode(buf []byte) (interface{}, error) {
+	if len(buf) != c.g.PointLen() {
+		return nil, fmt.Errorf("point of %s has %d bytes instead of %d",
+			c.g, len(buf), c.g.PointLen())
+	}
+	p := c.g.Point()
+	if err := p.UnmarshalBinary(buf); err != nil {
+		return nil, err
+	}
+	if err := canonical(p, buf); err != nil {
+		return nil, fmt.Errorf("point of %s: %v", c.g, err)
+	}
+	return p, nil
+}
+
+func (c pointCodec) ProtoType() string {
+	return "bytes"
+}
+
+// Scalar returns a codec for the scalars of g.
+func Scalar(g kyber.Group) protobuf.Codec {
+	return scalarCodec{g}
+}
+
+type scalarCodec struct {
+	g kyber.Group
+}
+
+func (c scalarCodec) Encode(v interface{}) ([]byte, error) {
+	return v.(kyber.Scalar).MarshalBinary()
+}
+
+func (c scalarCodec) Decode(buf []byte) (interface{}, error) {
+	if len(buf) != c.g.ScalarLen() {
+		return nil, fmt.Errorf("scalar of %s has %d bytes instead of %d",
+			c.g, len(buf), c.g.ScalarLen())
+	}
+	s := c.g.Scalar()
+	if err := s.UnmarshalBinary(buf); err != nil {
+		return nil, err
+	}
+	// Some groups accept unreduced scalars, which adding zero reduces.
+	reduced := c.g.Scalar().Add(s, c.g.Scalar().Zero())
+	if err := canonical(reduced, buf); err != nil {
+		return nil, fmt.Errorf("scalar of %s: %v", c.g, err)
+	}
+	return s, nil
+}
+
+func (c scalarCodec) ProtoType() string {
+	return "bytes"
+}
+
+// canonical checks that buf is the marshaling of m.
+func canonical(m kyber.Marshaling, buf []byte) error {
+	b, err := m.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(b, buf) {
+		return errors.New("non-canonical encoding")
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package kybercodec
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/suites"
+	"go.dedis.ch/protobuf"
+)
+
+type keys struct {
+	P   kyber.Point
+	S   kyber.Scalar
+	Ps  []kyber.Point
+	Ss  []kyber.Scalar
+	Nil kyber.Point
+}
+
+func testKeys(g kyber.Group) keys {
+	s := g.Scalar().SetInt64(42)
+	return keys{
+		P:  g.Point().Mul(s, nil),
+		S:  s,
+		Ps: []kyber.Point{g.Point().Base(), g.Point().Null()},
+		Ss: []kyber.Scalar{g.Scalar().One(), g.Scalar().Zero()},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, name := range []string{"ed25519", "bn256.adapter"} {
+		g := suites.MustFind(name)
+		cs := protobuf.NewCodecs()
+		Register(cs, g)
+		in := testKeys(g)
+
+		buf, err := protobuf.EncodeWithOptions(&in, protobuf.EncodeOptions{Codecs: cs})
+		require.NoError(t, err)
+		var out keys
+		require.NoError(t, protobuf.DecodeWithOptions(buf, &out, protobuf.DecodeOptions{Codecs: cs}))
+		assertEqualKeys(t, in, out)
+
+		// The default encoding decodes with the constructors.
+		buf, err = protobuf.Encode(&in)
+		require.NoError(t, err)
+		out = keys{}
+		require.NoError(t, protobuf.DecodeWithConstructors(buf, &out, Constructors(g)))
+		assertEqualKeys(t, in, out)
+	}
+}
+
+func assertEqualKeys(t *testing.T, in, out keys) {
+	assert.True(t, in.P.Equal(out.P))
+	assert.True(t, in.S.Equal(out.S))
+	require.Len(t, out.Ps, len(in.Ps))
+	for i := range in.Ps {
+		assert.True(t, in.Ps[i].Equal(out.Ps[i]))
+	}
+	require.Len(t, out.Ss, len(in.Ss))
+	for i := range in.Ss {
+		assert.True(t, in.Ss[i].Equal(out.Ss[i]))
+	}
+	assert.Nil(t, out.Nil)
+}
+
+func TestWireForm(t *testing.T) {
+	g := suites.MustFind("ed25519")
+	cs := protobuf.NewCodecs()
+	Register(cs, g)
+	in := struct{ P kyber.Point }{g.Point().Base()}
+	buf, err := protobuf.EncodeWithOptions(&in, protobuf.EncodeOptions{Codecs: cs})
+	require.NoError(t, err)
+	p, err := in.P.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte{0x0a, 32}, p...), buf)
+}
+
+func TestInvalidEncodings(t *testing.T) {
+	g := suites.MustFind("ed25519")
+	pc, sc := Point(g), Scalar(g)
+
+	_, err := pc.Decode(make([]byte, 31))
+	assert.Error(t, err)
+	_, err = sc.Decode(make([]byte, 33))
+	assert.Error(t, err)
+
+	// Find bytes that aren't the encoding of a point.
+	b := make([]byte, 32)
+	for i := byte(2); g.Point().UnmarshalBinary(b) == nil; i++ {
+		b[0] = i
+	}
+	_, err = pc.Decode(b)
+	assert.Error(t, err)
+
+	// The identity point, with a y coordinate of p+1
+	// instead of 1, is rejected as non-canonical.
+	b = bytes.Repeat([]byte{0xff}, 32)
+	b[0] = 0xee
+	b[31] = 0x7f
+	require.NoError(t, g.Point().UnmarshalBinary(b))
+	_, err = pc.Decode(b)
+	assert.Error(t, err)
+
+	// So is an unreduced scalar.
+	_, err = sc.Decode(bytes.Repeat([]byte{0xff}, 32))
+	assert.Error(t, err)
+
+	// Errors are reported for the field.
+	cs := protobuf.NewCodecs()
+	Register(cs, g)
+	var out keys
+	err = protobuf.DecodeWithOptions(append([]byte{0x0a, 32}, b...), &out, protobuf.DecodeOptions{Codecs: cs})
+	assert.IsType(t, &protobuf.DecodeError{}, err)
+}
+
+func TestGenerate(t *testing.T) {
+	cs := protobuf.NewCodecs()
+	Register(cs, suites.MustFind("ed25519"))
+	w := &bytes.Buffer{}
+	opts := protobuf.GenerateOptions{Codecs: cs}
+	require.NoError(t, protobuf.GenerateWithOptions(w, []interface{}{keys{}}, nil, nil, opts))
+	expected := `
+message keys {
+  required bytes p = 1;
+  required bytes s = 2;
+  repeated bytes ps = 3;
+  repeated bytes ss = 4;
+  required bytes nil = 5;
+}
+
+`
+	assert.Equal(t, expected, w.String())
+}
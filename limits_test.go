@@ -0,0 +1,113 @@
+package protobuf
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type node struct {
+	V    uint32
+	Next *node
+}
+
+func chain(n int) *node {
+	var head *node
+	for i := n; i > 0; i-- {
+		head = &node{V: uint32(i), Next: head}
+	}
+	return head
+}
+
+func requireLimit(t *testing.T, err error, limit string) *DecodeError {
+	var le *LimitError
+	require.True(t, errors.As(err, &le), "expected a LimitError, got %v", err)
+	assert.Equal(t, limit, le.Limit)
+	var de *DecodeError
+	require.True(t, errors.As(err, &de))
+	return de
+}
+
+func TestLimitDepth(t *testing.T) {
+	buf, err := Encode(chain(5))
+	require.NoError(t, err)
+
+	var n node
+	require.NoError(t, DecodeWithOptions(buf, &n, DecodeOptions{MaxDepth: 5}))
+	assert.Equal(t, chain(5), &n)
+
+	err = DecodeWithOptions(buf, &n, DecodeOptions{MaxDepth: 3})
+	de := requireLimit(t, err, "MaxDepth")
+	assert.Equal(t, "node.next.next.next", de.Path)
+}
+
+func TestLimitMessageSize(t *testing.T) {
+	buf, err := Encode(chain(5))
+	require.NoError(t, err)
+
+	var n node
+	require.NoError(t, DecodeWithOptions(buf, &n, DecodeOptions{MaxMessageSize: len(buf)}))
+	err = DecodeWithOptions(buf, &n, DecodeOptions{MaxMessageSize: len(buf) - 1})
+	de := requireLimit(t, err, "MaxMessageSize")
+	assert.Equal(t, "node", de.Path)
+}
+
+type repeatedLimits struct {
+	Packed   []uint32
+	Unpacked []string
+	Map      map[uint32]string
+}
+
+func TestLimitRepeatedElements(t *testing.T) {
+	in := repeatedLimits{
+		Packed:   []uint32{1, 2, 3, 4},
+		Unpacked: []string{"a", "b", "c", "d"},
+	}
+	buf, err := Encode(&in)
+	require.NoError(t, err)
+
+	var out repeatedLimits
+	require.NoError(t, DecodeWithOptions(buf, &out, DecodeOptions{MaxRepeatedElements: 4}))
+	assert.Equal(t, in, out)
+
+	err = DecodeWithOptions(buf, &out, DecodeOptions{MaxRepeatedElements: 3})
+	de := requireLimit(t, err, "MaxRepeatedElements")
+	assert.Equal(t, "repeatedLimits.packed", de.Path)
+	assert.Equal(t, 5, de.Offset)
+
+	// The limit applies to the field as a whole,
+	// even when it occurs several times.
+	err = DecodeWithOptions(append(buf, buf...), &out, DecodeOptions{MaxRepeatedElements: 6})
+	requireLimit(t, err, "MaxRepeatedElements")
+
+	// The limit is checked before an extra message element is parsed,
+	// so a malformed one isn't reached.
+	var msgs struct{ L []proto3Inner }
+	buf = []byte{0x0a, 2, 0x08, 0x02, 0x0a, 1, 0xff}
+	err = DecodeWithOptions(buf, &msgs, DecodeOptions{MaxRepeatedElements: 1})
+	de = requireLimit(t, err, "MaxRepeatedElements")
+	assert.Equal(t, 6, de.Offset)
+}
+
+func TestLimitMapEntries(t *testing.T) {
+	in := repeatedLimits{Map: map[uint32]string{1: "a", 2: "b", 3: "c"}}
+	buf, err := Encode(&in)
+	require.NoError(t, err)
+
+	var out repeatedLimits
+	require.NoError(t, DecodeWithOptions(buf, &out, DecodeOptions{MaxMapEntries: 3}))
+	assert.Equal(t, in, out)
+
+	err = DecodeWithOptions(buf, &out, DecodeOptions{MaxMapEntries: 2})
+	de := requireLimit(t, err, "MaxMapEntries")
+	assert.Equal(t, int64(3), de.Field)
+
+	// Replacing an existing entry doesn't count against the limit.
+	buf, err = Encode(&repeatedLimits{Map: map[uint32]string{1: "x"}})
+	require.NoError(t, err)
+	dup := append(buf, buf...)
+	require.NoError(t, DecodeWithOptions(dup, &out, DecodeOptions{MaxMapEntries: 1}))
+	assert.Equal(t, map[uint32]string{1: "x"}, out.Map)
+}
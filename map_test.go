@@ -2,6 +2,7 @@ package protobuf
 
 import (
 	"bytes"
+	"fmt"
 	"reflect"
 	"testing"
 
@@ -145,3 +146,76 @@ func TestMapWrongSliceValue(t *testing.T) {
 	_, err := Encode(w)
 	assert.NotNil(t, err)
 }
+
+func TestMapDeterministic(t *testing.T) {
+	m := &MessageWithMap{
+		NameMapping: map[uint32]string{8: "Dave", 1: "Rob", 4: "Ian"},
+	}
+	b, err := EncodeWithOptions(m, EncodeOptions{Deterministic: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "\n\a\b\x01\x12\x03Rob"+"\n\a\b\x04\x12\x03Ian"+"\n\b\b\x08\x12\x04Dave", string(b))
+}
+
+type MessageWithAllKeys struct {
+	Bools   map[bool]string
+	Ints    map[int64]string
+	Int32s  map[int32]string
+	Uints   map[uint64]string
+	Strings map[string]string
+	Arrays  map[[4]byte]string
+	Nested  map[string]*MessageWithMap
+}
+
+func TestMapDeterministicRepeatable(t *testing.T) {
+	m := &MessageWithAllKeys{
+		Bools:   map[bool]string{},
+		Ints:    map[int64]string{},
+		Int32s:  map[int32]string{},
+		Uints:   map[uint64]string{},
+		Strings: map[string]string{},
+		Arrays:  map[[4]byte]string{},
+		Nested:  map[string]*MessageWithMap{},
+	}
+	m.Bools[true] = "t"
+	m.Bools[false] = "f"
+	for i := 0; i < 50; i++ {
+		s := fmt.Sprint(i)
+		m.Ints[int64(i*7919%101-50)] = s
+		m.Int32s[int32(-i)] = s
+		m.Uints[uint64(i)<<40] = s
+		m.Strings["key"+s] = s
+		m.Arrays[[4]byte{byte(i % 3), byte(i)}] = s
+		m.Nested[s] = &MessageWithMap{StrToStr: map[string]string{s: s, s + s: s}}
+	}
+
+	first, err := EncodeWithOptions(m, EncodeOptions{Deterministic: true})
+	assert.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		b, err := EncodeWithOptions(m, EncodeOptions{Deterministic: true})
+		assert.NoError(t, err)
+		if !bytes.Equal(first, b) {
+			t.Fatalf("deterministic encoding differs on run %d", i)
+		}
+	}
+
+	var m2 MessageWithAllKeys
+	assert.NoError(t, Decode(first, &m2))
+	assert.Equal(t, m, &m2)
+}
+
+func TestMapDeterministicDefault(t *testing.T) {
+	defer func(opts EncodeOptions) { DefaultEncodeOptions = opts }(DefaultEncodeOptions)
+	DefaultEncodeOptions.Deterministic = true
+
+	m := &MessageWithMap{StrToStr: map[string]string{}}
+	for i := 0; i < 20; i++ {
+		m.StrToStr[fmt.Sprint(i)] = fmt.Sprint(i)
+	}
+	first, err := Encode(m)
+	assert.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		b, err := Encode(m)
+		assert.NoError(t, err)
+		assert.Equal(t, first, b)
+	}
+}
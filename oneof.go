@@ -0,0 +1,236 @@
+package protobuf
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// oneofMember is one of the alternatives of a oneof group.
+type oneofMember struct {
+	typ   reflect.Type // Registered wrapper type, a struct or a pointer to one
+	field *ProtoField  // The wrapper's only field, numbered as the member
+}
+
+// oneofGroup holds the members registered for a oneof interface type.
+type oneofGroup struct {
+	byID   map[int64]*oneofMember
+	byType map[reflect.Type]*oneofMember
+}
+
+var oneofGroups = map[reflect.Type]*oneofGroup{}
+var oneofLock sync.Mutex
+
+// RegisterOneof declares the members of a oneof group.
+// The group is a struct field of interface type tagged "oneof",
+// which doesn't take up a field number itself:
+//
+//	type Payload interface{ isPayload() }
+//
+//	type PayloadText struct {
+//		Text string `protobuf:"2"`
+//	}
+//
+//	type PayloadCount struct {
+//		Count int64 `protobuf:"3"`
+//	}
+//
+//	type Message struct {
+//		ID      int64   // = 1
+//		Payload Payload `protobuf:"oneof"`
+//	}
+//
+//	RegisterOneof((*Payload)(nil), PayloadText{}, PayloadCount{})
+//
+// iface is a nil pointer to the interface type, and each member is
+// a value of a wrapper struct type, or a pointer to one, implementing it.
+// The wrapper has exactly one field, whose number and type
+// are those of the member in the oneof.
+// Encode writes only the member the field holds, if any,
+// even if the member's field is zero or a nil pointer,
+// and Decode instantiates the member matching the field number it reads.
+// RegisterOneof panics if the arguments don't describe a valid group.
+func RegisterOneof(iface interface{}, members ...interface{}) {
+	it := reflect.TypeOf(iface)
+	if it == nil || it.Kind() != reflect.Ptr || it.Elem().Kind() != reflect.Interface {
+		panic("RegisterOneof takes a nil pointer to an interface type")
+	}
+	it = it.Elem()
+	group := &oneofGroup{
+		byID:   map[int64]*oneofMember{},
+		byType: map[reflect.Type]*oneofMember{},
+	}
+	for _, m := range members {
+		t := reflect.TypeOf(m)
+		if t == nil || !t.Implements(it) {
+			panic(fmt.Sprintf("oneof member %v does not implement %s", t, it))
+		}
+		st := typeIndirect(t)
+		if st.Kind() != reflect.Struct {
+			panic(fmt.Sprintf("oneof member %s is not a struct", t))
+		}
+		fields := ProtoFields(st)
+		if len(fields) != 1 {
+			panic(fmt.Sprintf("oneof member %s must have exactly one field", t))
+		}
+		f := fields[0]
+		switch k := f.Field.Type.Kind(); {
+		case k == reflect.Map,
+			k == reflect.Slice && f.Field.Type.Elem().Kind() != reflect.Uint8:
+			panic(fmt.Sprintf("oneof member %s cannot be repeated", t))
+		}
+		if _, ok := group.byID[f.ID]; ok {
+			panic(fmt.Sprintf("protobuf ID %d reused in oneof %s", f.ID, it))
+		}
+		member := &oneofMember{typ: t, field: f}
+		group.byID[f.ID] = member
+		group.byType[t] = member
+	}
+	oneofLock.Lock()
+	defer oneofLock.Unlock()
+	oneofGroups[it] = group
+}
+
+// getOneofGroup returns the members registered for interface type t,
+// or nil if there are none.
+func getOneofGroup(t reflect.Type) *oneofGroup {
+	oneofLock.Lock()
+	defer oneofLock.Unlock()
+	return oneofGroups[t]
+}
+
+// activeMember returns the oneof member that field f of message sval holds,
+// and its wrapper struct, or a nil member if the field is nil.
+func activeMember(f *ProtoField, sval reflect.Value) (*oneofMember, reflect.Value, error) {
+	val := sval.FieldByIndex(f.Index)
+	if val.IsNil() {
+		return nil, reflect.Value{}, nil
+	}
+	group := getOneofGroup(f.Field.Type)
+	if group == nil {
+		return nil, reflect.Value{}, fmt.Errorf("no members registered for oneof %s", f.Field.Type)
+	}
+	w := val.Elem()
+	member := group.byType[w.Type()]
+	if member == nil {
+		return nil, reflect.Value{}, fmt.Errorf("%s is not a member of oneof %s", w.Type(), f.Field.Type)
+	}
+	if w.Kind() == reflect.Ptr {
+		if w.IsNil() {
+			return nil, reflect.Value{}, fmt.Errorf("oneof member %s is nil", w.Type())
+		}
+		w = w.Elem()
+	}
+	return member, w, nil
+}
+
+// oneof writes the active member of the oneof field f of message sval.
+func (en *encoder) oneof(f *ProtoField, sval reflect.Value) error {
+	en.path.push(f)
+	member, w, err := activeMember(f, sval)
+	if err != nil {
+		err = en.wrap(f.Field.Type, err)
+	}
+	en.path.pop()
+	if member == nil || err != nil {
+		return err
+	}
+
+	// The member is written even if it holds a zero value,
+	// since its presence is what tells the members apart.
+	// A nil pointer is written as a pointer to zero.
+	en.path.push(member.field)
+	defer en.path.pop()
+	val := w.FieldByIndex(member.field.Index)
+	if val.Kind() == reflect.Ptr && val.IsNil() {
+		val = reflect.New(val.Type().Elem())
+	}
+	if err := en.value(uint64(member.field.ID)<<3, val, member.field); err != nil {
+		return en.wrap(val.Type(), err)
+	}
+	return nil
+}
+
+// oneofTarget is where the decoder puts a oneof member it reads.
+type oneofTarget struct {
+	member  *oneofMember
+	field   reflect.Value // The oneof interface field
+	wrapper reflect.Value // Pointer to the wrapper to decode into
+}
+
+// value returns the wrapper's field to decode the member into.
+func (t *oneofTarget) value() reflect.Value {
+	return t.wrapper.Elem().FieldByIndex(t.member.field.Index)
+}
+
+// store sets the oneof field to the decoded wrapper.
+func (t *oneofTarget) store() {
+	if t.member.typ.Kind() == reflect.Ptr {
+		t.field.Set(t.wrapper)
+	} else {
+		t.field.Set(t.wrapper.Elem())
+	}
+}
+
+// findOneofTarget looks for a oneof member numbered fieldnum in message sval.
+// It returns nil if there is no such member.
+// Decoding merges into the current member if it has the same type,
+// and replaces it otherwise.
+func findOneofTarget(info *typeInfo, sval reflect.Value, fieldnum int64) *oneofTarget {
+	for _, f := range info.oneofs {
+		group := getOneofGroup(f.Field.Type)
+		if group == nil {
+			continue
+		}
+		member := group.byID[fieldnum]
+		if member == nil {
+			continue
+		}
+		t := &oneofTarget{member: member, field: sval.FieldByIndex(f.Index)}
+		var cur reflect.Value
+		if !t.field.IsNil() && t.field.Elem().Type() == member.typ {
+			cur = t.field.Elem()
+		}
+		if member.typ.Kind() == reflect.Ptr {
+			if cur.IsValid() && !cur.IsNil() {
+				t.wrapper = cur
+			} else {
+				t.wrapper = reflect.New(member.typ.Elem())
+			}
+		} else {
+			// Interface values aren't addressable, so decode into a copy.
+			t.wrapper = reflect.New(member.typ)
+			if cur.IsValid() {
+				t.wrapper.Elem().Set(cur)
+			}
+		}
+		return t
+	}
+	return nil
+}
+
+// oneofs returns the oneof groups of a message type
+// and their members in field number order, for the generator.
+func oneofs(t reflect.Type) []oneofDef {
+	var defs []oneofDef
+	for _, f := range getTypeInfo(t).oneofs {
+		def := oneofDef{Field: f}
+		if group := getOneofGroup(f.Field.Type); group != nil {
+			for _, m := range group.byID {
+				def.Members = append(def.Members, m.field)
+			}
+		}
+		sort.Slice(def.Members, func(i, j int) bool {
+			return def.Members[i].ID < def.Members[j].ID
+		})
+		defs = append(defs, def)
+	}
+	return defs
+}
+
+// oneofDef describes a oneof group of a message for the generator.
+type oneofDef struct {
+	Field   *ProtoField
+	Members []*ProtoField
+}
@@ -0,0 +1,141 @@
+package protobuf
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type oneofPayload interface {
+	isOneofPayload()
+}
+
+type oneofText struct {
+	Text string `protobuf:"2"`
+}
+
+type oneofCount struct {
+	Count int64 `protobuf:"3"`
+}
+
+type oneofInner struct {
+	Inner *emb `protobuf:"4"`
+}
+
+func (oneofText) isOneofPayload()   {}
+func (oneofCount) isOneofPayload()  {}
+func (*oneofInner) isOneofPayload() {}
+
+type oneofMsg struct {
+	ID      int64
+	Payload oneofPayload `protobuf:"oneof"`
+	Extra   string       `protobuf:"5"`
+}
+
+type notAMember struct {
+	X int32
+}
+
+type twoFields struct {
+	X, Y int32
+}
+
+func (notAMember) isOneofPayload() {}
+func (twoFields) isOneofPayload()  {}
+
+func init() {
+	RegisterOneof((*oneofPayload)(nil), oneofText{}, oneofCount{}, &oneofInner{})
+}
+
+func TestOneofRoundTrip(t *testing.T) {
+	for _, p := range []oneofPayload{
+		nil,
+		oneofText{"hello"},
+		oneofText{},
+		oneofCount{-7},
+		&oneofInner{&emb{I32: 3}},
+	} {
+		in := oneofMsg{ID: 1, Payload: p, Extra: "x"}
+		buf, err := Encode(&in)
+		require.NoError(t, err)
+		var out oneofMsg
+		require.NoError(t, Decode(buf, &out))
+		assert.Equal(t, in, out)
+	}
+}
+
+func TestOneofEncoding(t *testing.T) {
+	buf, err := Encode(&oneofMsg{ID: 1, Payload: oneofCount{1}})
+	require.NoError(t, err)
+	// Oneof members are written after the other fields.
+	assert.Equal(t, []byte{0x08, 0x02, 0x2a, 0x00, 0x18, 0x02}, buf)
+
+	// A zero-valued member is still written, unlike a nil oneof.
+	buf, err = Encode(&oneofMsg{ID: 1, Payload: oneofText{}})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x08, 0x02, 0x2a, 0x00, 0x12, 0x00}, buf)
+
+	buf, err = Encode(&oneofMsg{ID: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x08, 0x02, 0x2a, 0x00}, buf)
+}
+
+func TestOneofNilMemberField(t *testing.T) {
+	// A member holding a nil pointer is written as a pointer to zero,
+	// so that the oneof survives the round trip.
+	buf, err := Encode(&oneofMsg{ID: 1, Payload: &oneofInner{}})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x08, 0x02, 0x2a, 0x00, 0x22, 0x04, 0x08, 0x00, 0x12, 0x00}, buf)
+
+	var out oneofMsg
+	require.NoError(t, Decode(buf, &out))
+	assert.Equal(t, &oneofInner{&emb{}}, out.Payload)
+}
+
+func TestOneofLastMemberWins(t *testing.T) {
+	// text = "a", count = 1
+	buf := []byte{0x12, 0x01, 'a', 0x18, 0x02}
+	out := oneofMsg{Payload: oneofText{"old"}}
+	require.NoError(t, Decode(buf, &out))
+	assert.Equal(t, oneofCount{1}, out.Payload)
+
+	// A message not mentioning the oneof clears it.
+	require.NoError(t, Decode([]byte{0x08, 0x02}, &out))
+	assert.Nil(t, out.Payload)
+}
+
+func TestOneofUnknownMember(t *testing.T) {
+	_, err := Encode(&oneofMsg{Payload: notAMember{}})
+	var ee *EncodeError
+	require.True(t, errors.As(err, &ee), "%v", err)
+	assert.Equal(t, "oneofMsg.payload", ee.Path)
+	assert.Contains(t, err.Error(), "not a member of oneof")
+}
+
+func TestRegisterOneofInvalid(t *testing.T) {
+	assert.Panics(t, func() { RegisterOneof(oneofText{}, oneofText{}) })
+	assert.Panics(t, func() { RegisterOneof((*oneofPayload)(nil), &oneofText{}, oneofText{}) })
+	assert.Panics(t, func() { RegisterOneof((*oneofPayload)(nil), twoFields{}) })
+}
+
+func TestGenerateOneof(t *testing.T) {
+	w := &bytes.Buffer{}
+	err := GenerateProtobufDefinition(w, []interface{}{oneofMsg{}}, nil, nil)
+	require.NoError(t, err)
+	expected := `
+message oneofMsg {
+  required sint64 id = 1;
+  required string extra = 5;
+  oneof payload {
+    string text = 2;
+    sint64 count = 3;
+    emb inner = 4;
+  }
+}
+
+`
+	assert.Equal(t, expected, w.String())
+}
@@ -0,0 +1,91 @@
+package protobuf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type descendingTags struct {
+	A int32  `protobuf:"5"`
+	B string `protobuf:"2"`
+	C uint64 `protobuf:"1"`
+}
+
+func TestDescendingTagsRoundTrip(t *testing.T) {
+	in := descendingTags{A: -5, B: "two", C: 1}
+	buf, err := Encode(&in)
+	require.NoError(t, err)
+
+	var out descendingTags
+	require.NoError(t, Decode(buf, &out))
+	assert.Equal(t, in, out)
+}
+
+func TestDecodeOutOfOrder(t *testing.T) {
+	// Person{Id: 123, Name: "Alice"} with field 2 written before field 1.
+	buf := []byte{0x10, 0xf6, 0x01, 0x0a, 0x05, 'A', 'l', 'i', 'c', 'e'}
+
+	var p Person
+	require.NoError(t, Decode(buf, &p))
+	assert.Equal(t, Person{Name: "Alice", Id: 123}, p)
+}
+
+type concatInner struct {
+	X int32
+	Y *string
+}
+
+type concatOuter struct {
+	N     uint32
+	List  []uint32
+	Names []string
+	Inner concatInner
+	Ptr   *concatInner
+}
+
+func TestDecodeConcatenated(t *testing.T) {
+	y := "y"
+	m1 := concatOuter{
+		N:     1,
+		List:  []uint32{1, 2},
+		Names: []string{"a"},
+		Inner: concatInner{X: 1, Y: &y},
+		Ptr:   &concatInner{X: 10, Y: &y},
+	}
+	m2 := concatOuter{
+		N:     2,
+		List:  []uint32{3},
+		Names: []string{"b", "c"},
+		Inner: concatInner{X: 2},
+		Ptr:   &concatInner{X: 20},
+	}
+	b1, err := Encode(&m1)
+	require.NoError(t, err)
+	b2, err := Encode(&m2)
+	require.NoError(t, err)
+
+	var out concatOuter
+	require.NoError(t, Decode(append(b1, b2...), &out))
+
+	// Scalars take the last value, repeated fields are concatenated
+	// and embedded messages are merged.
+	assert.Equal(t, concatOuter{
+		N:     2,
+		List:  []uint32{1, 2, 3},
+		Names: []string{"a", "b", "c"},
+		Inner: concatInner{X: 2, Y: &y},
+		Ptr:   &concatInner{X: 20, Y: &y},
+	}, out)
+}
+
+func TestDecodeResetsTarget(t *testing.T) {
+	buf, err := Encode(&concatOuter{N: 7})
+	require.NoError(t, err)
+
+	y := "stale"
+	out := concatOuter{List: []uint32{9}, Inner: concatInner{Y: &y}}
+	require.NoError(t, Decode(buf, &out))
+	assert.Equal(t, concatOuter{N: 7}, out)
+}
@@ -0,0 +1,125 @@
+package protobuf
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type repeatedScalars struct {
+	I64   []int64
+	U32   []uint32
+	B     []bool
+	F32   []float32
+	F64   []float64
+	SX32  []Sfixed32
+	UX64  []Ufixed64
+	Enums []Enum
+}
+
+type unpackedScalars struct {
+	I64   []int64    `protobuf:"packed=false"`
+	U32   []uint32   `protobuf:"packed=false"`
+	B     []bool     `protobuf:"packed=false"`
+	F32   []float32  `protobuf:"packed=false"`
+	F64   []float64  `protobuf:"packed=false"`
+	SX32  []Sfixed32 `protobuf:"packed=false"`
+	UX64  []Ufixed64 `protobuf:"packed=false"`
+	Enums []Enum     `protobuf:"packed=false"`
+	Names []string   `protobuf:"packed=false"`
+}
+
+func TestDecodeUnpacked(t *testing.T) {
+	buf := []byte{
+		0x08, 0x03, // i64 = -2
+		0x08, 0x04, // i64 = 2
+		0x10, 0x96, 0x01, // u32 = 150
+		0x18, 0x01, // b = true
+		0x25, 0x00, 0x00, 0x80, 0x3f, // f32 = 1
+		0x29, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f, // f64 = 1
+		0x35, 0xff, 0xff, 0xff, 0xff, // sx32 = -1
+		0x39, 7, 0, 0, 0, 0, 0, 0, 0, // ux64 = 7
+		0x40, 0x02, // enums = 2
+		0x42, 0x02, 0x03, 0x04, // enums = [3, 4], packed
+		0x40, 0x05, // enums = 5
+	}
+	var out repeatedScalars
+	require.NoError(t, Decode(buf, &out))
+	assert.Equal(t, repeatedScalars{
+		I64:   []int64{-2, 2},
+		U32:   []uint32{150},
+		B:     []bool{true},
+		F32:   []float32{1},
+		F64:   []float64{1},
+		SX32:  []Sfixed32{-1},
+		UX64:  []Ufixed64{7},
+		Enums: []Enum{2, 3, 4, 5},
+	}, out)
+}
+
+func TestDecodeUnpackedWrongWireType(t *testing.T) {
+	// A float64 element can't be sent as a varint.
+	var out repeatedScalars
+	assert.Error(t, Decode([]byte{0x28, 0x01}, &out))
+
+	// Neither can a string.
+	var strs struct{ S []string }
+	assert.Error(t, Decode([]byte{0x08, 0x01}, &strs))
+}
+
+func TestEncodeUnpacked(t *testing.T) {
+	in := unpackedScalars{
+		I64:   []int64{-2, 2},
+		U32:   []uint32{150},
+		B:     []bool{true, false},
+		F32:   []float32{1},
+		F64:   []float64{1},
+		SX32:  []Sfixed32{-1},
+		UX64:  []Ufixed64{7},
+		Enums: []Enum{2, 3},
+		Names: []string{"a"},
+	}
+	buf, err := Encode(&in)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x08, 0x03, 0x08, 0x04,
+		0x10, 0x96, 0x01,
+		0x18, 0x01, 0x18, 0x00,
+		0x25, 0x00, 0x00, 0x80, 0x3f,
+		0x29, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f,
+		0x35, 0xff, 0xff, 0xff, 0xff,
+		0x39, 7, 0, 0, 0, 0, 0, 0, 0,
+		0x40, 0x02, 0x40, 0x03,
+		0x4a, 0x01, 'a',
+	}, buf)
+
+	var out unpackedScalars
+	require.NoError(t, Decode(buf, &out))
+	assert.Equal(t, in, out)
+
+	// Packed and unpacked forms are interchangeable.
+	var packed repeatedScalars
+	require.NoError(t, Decode(buf, &packed))
+	assert.Equal(t, in.I64, packed.I64)
+	assert.Equal(t, in.Enums, packed.Enums)
+}
+
+func TestGenerateUnpacked(t *testing.T) {
+	type unpackedMsg struct {
+		Packed   []int32
+		Unpacked []int32 `protobuf:"packed=false"`
+	}
+	w := &bytes.Buffer{}
+	err := GenerateProtobufDefinition(w, []interface{}{unpackedMsg{}}, nil, nil)
+	require.NoError(t, err)
+	assert.Contains(t, w.String(), "  repeated sint32 packed = 1 [packed=true];\n")
+	assert.Contains(t, w.String(), "  repeated sint32 unpacked = 2;\n")
+
+	w.Reset()
+	err = GenerateProto3Definition(w, []interface{}{unpackedMsg{}}, nil, nil)
+	require.NoError(t, err)
+	assert.Contains(t, w.String(), "  repeated sint32 packed = 1;\n")
+	assert.Contains(t, w.String(), "  repeated sint32 unpacked = 2 [packed=false];\n")
+}
@@ -0,0 +1,143 @@
+package protobuf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type proto3Inner struct {
+	A int64
+}
+
+func (proto3Inner) Proto3() bool { return true }
+
+type proto3Msg struct {
+	I     int32
+	U     uint64
+	B     bool
+	F     float64
+	S     string
+	Bytes []byte
+	List  []int64
+	M     map[string]int32
+	Opt   *int32
+	Inner proto3Inner
+}
+
+func (proto3Msg) Proto3() bool { return true }
+
+// plainMsg has the layout of proto3Msg without implementing Proto3Message.
+type plainMsg struct {
+	I     int32
+	U     uint64
+	B     bool
+	F     float64
+	S     string
+	Bytes []byte
+	List  []int64
+	M     map[string]int32
+	Opt   *int32
+	Inner proto3Inner
+}
+
+func TestProto3OmitsZeroValues(t *testing.T) {
+	// Embedded messages have explicit presence, so they are still written.
+	buf, err := Encode(&proto3Msg{})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x52, 0x00}, buf)
+
+	zero := int32(0)
+	buf, err = Encode(&proto3Msg{Opt: &zero})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x48, 0x00, 0x52, 0x00}, buf)
+
+	// Set values are written as usual.
+	buf, err = Encode(&proto3Msg{I: -1, S: "x"})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x08, 0x01, 0x2a, 0x01, 'x', 0x52, 0x00}, buf)
+}
+
+func TestProto3Option(t *testing.T) {
+	in := plainMsg{S: "x"}
+	buf, err := Encode(&in)
+	require.NoError(t, err)
+	assert.True(t, len(buf) > 7, "proto2 encoding writes zero values")
+
+	buf, err = EncodeWithOptions(&in, EncodeOptions{Proto3: true})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x2a, 0x01, 'x', 0x52, 0x00}, buf)
+}
+
+func TestProto3RoundTrip(t *testing.T) {
+	one := int32(1)
+	in := proto3Msg{
+		I: 2, U: 3, B: true, F: 4.5, S: "s",
+		Bytes: []byte{6}, List: []int64{7, 8},
+		M:     map[string]int32{"k": 9},
+		Opt:   &one,
+		Inner: proto3Inner{A: 10},
+	}
+	buf, err := Encode(&in)
+	require.NoError(t, err)
+	var out proto3Msg
+	require.NoError(t, Decode(buf, &out))
+	assert.Equal(t, in, out)
+
+	// Absent fields decode as zero values, overwriting what was there.
+	buf, err = Encode(&proto3Msg{})
+	require.NoError(t, err)
+	require.NoError(t, Decode(buf, &out))
+	assert.Equal(t, proto3Msg{Inner: proto3Inner{}}, out)
+}
+
+func TestProto3StrictDecode(t *testing.T) {
+	opts := DecodeOptions{Strict: true}
+	var out proto3Msg
+	assert.NoError(t, DecodeWithOptions(nil, &out, opts))
+
+	var plain plainMsg
+	err := DecodeWithOptions(nil, &plain, opts)
+	assert.IsType(t, &RequiredFieldsError{}, err)
+
+	opts.Proto3 = true
+	assert.NoError(t, DecodeWithOptions(nil, &plain, opts))
+}
+
+func TestGenerateProto3(t *testing.T) {
+	expected := `syntax = "proto3";
+
+message proto3Msg {
+  sint32 i = 1;
+  uint64 u = 2;
+  bool b = 3;
+  double f = 4;
+  string s = 5;
+  bytes bytes = 6;
+  repeated sint64 list = 7;
+  map<string, sint32> m = 8;
+  optional sint32 opt = 9;
+  proto3Inner inner = 10;
+}
+
+`
+	w := &bytes.Buffer{}
+	err := GenerateProtobufDefinition(w, []interface{}{proto3Msg{}}, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, expected, w.String())
+
+	w.Reset()
+	err = GenerateProto3Definition(w, []interface{}{plainMsg{}}, nil, nil)
+	require.NoError(t, err)
+	assert.Contains(t, w.String(), `syntax = "proto3";`)
+	// Pointer fields keep explicit presence, as proto3 optional fields.
+	assert.Contains(t, w.String(), "  optional sint32 opt = 9;\n")
+	assert.Equal(t, 1, strings.Count(w.String(), "optional"))
+	assert.NotContains(t, w.String(), "required")
+
+	err = GenerateProtobufDefinition(w, []interface{}{proto3Msg{}, plainMsg{}}, nil, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,89 @@
+package protobuf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type shape interface {
+	Area() int
+}
+
+type square struct {
+	Side int
+}
+
+func (s square) Area() int {
+	return s.Side * s.Side
+}
+
+type rect struct {
+	W, H int
+	Name string
+}
+
+func (r *rect) Area() int {
+	return r.W * r.H
+}
+
+type shapes struct {
+	A shape
+	B shape
+}
+
+func TestRegisterTypeRoundTrip(t *testing.T) {
+	r := NewRegistry()
+	_, err := r.RegisterType("square", square{})
+	require.NoError(t, err)
+	_, err = r.RegisterType("rect", &rect{})
+	require.NoError(t, err)
+
+	in := shapes{A: square{3}, B: &rect{2, 5, "r"}}
+	for _, envelope := range []bool{false, true} {
+		buf, err := EncodeWithOptions(&in, EncodeOptions{Registry: r, Envelope: envelope})
+		require.NoError(t, err)
+
+		mode := EnvelopeNever
+		if envelope {
+			mode = EnvelopeAlways
+		}
+		var out shapes
+		require.NoError(t, DecodeWithOptions(buf, &out, DecodeOptions{Registry: r, Envelope: mode}))
+		assert.Equal(t, in, out)
+		assert.Equal(t, 9, out.A.Area())
+		assert.Equal(t, 10, out.B.Area())
+	}
+}
+
+func TestRegisterTypeWireFormat(t *testing.T) {
+	r := NewRegistry()
+	id, err := r.RegisterType("square", square{})
+	require.NoError(t, err)
+	assert.Equal(t, NameGeneratorID("square"), id)
+
+	buf, err := EncodeWithOptions(&shapes{A: square{3}}, EncodeOptions{Registry: r})
+	require.NoError(t, err)
+	expected := append([]byte{0x0a, 10}, id[:]...)
+	expected = append(expected, 0x08, 0x06)
+	assert.Equal(t, expected, buf)
+}
+
+func TestRegisterTypeErrors(t *testing.T) {
+	r := NewRegistry()
+	_, err := r.RegisterType("int", 1)
+	assert.Error(t, err)
+	_, err = r.RegisterType("nil", nil)
+	assert.Error(t, err)
+
+	_, err = r.RegisterType("square", square{})
+	require.NoError(t, err)
+	_, err = r.RegisterType("square", &rect{})
+	assert.Error(t, err)
+	_, err = r.RegisterType("other", square{})
+	assert.Error(t, err)
+	// The same registration again is fine.
+	_, err = r.RegisterType("square", square{})
+	assert.NoError(t, err)
+}
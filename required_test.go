@@ -0,0 +1,108 @@
+package protobuf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// partialPerson has the same field numbers as Person,
+// but leaves out the required id and the numbers of some phones.
+type partialPerson struct {
+	Name  string
+	_     struct{}
+	Email *string
+	Phone []partialPhoneNumber
+}
+
+type partialPhoneNumber struct {
+	Number *string
+	Type   *PhoneType
+}
+
+func TestStrictDecodeMissingFields(t *testing.T) {
+	number := "111-222-3333"
+	ptype := WORK
+	in := partialPerson{
+		Name: "Alice",
+		Phone: []partialPhoneNumber{
+			{Number: &number},
+			{Type: &ptype},
+			{},
+		},
+	}
+	buf, err := Encode(&in)
+	require.NoError(t, err)
+
+	// A lenient decode succeeds and leaves the zero values.
+	var p Person
+	require.NoError(t, Decode(buf, &p))
+	assert.Equal(t, "Alice", p.Name)
+
+	err = DecodeWithOptions(buf, &p, DecodeOptions{Strict: true})
+	require.Error(t, err)
+	rf, ok := err.(*RequiredFieldsError)
+	require.True(t, ok)
+	assert.Equal(t, []string{
+		"Person.phone[1].number",
+		"Person.phone[2].number",
+		"Person.id",
+	}, rf.Fields)
+	assert.Equal(t, "protobuf: missing required fields: Person.phone[1].number, "+
+		"Person.phone[2].number, Person.id", err.Error())
+}
+
+func TestStrictDecodeComplete(t *testing.T) {
+	email := "alice@somewhere"
+	in := Person{Name: "Alice", Id: 123, Email: &email,
+		Phone: []PhoneNumber{{Number: "111-222-3333"}}}
+	buf, err := Encode(&in)
+	require.NoError(t, err)
+
+	var out Person
+	require.NoError(t, DecodeWithOptions(buf, &out, DecodeOptions{Strict: true}))
+	assert.Equal(t, in, out)
+
+	// Empty repeated fields and unset optional fields are not required.
+	buf, err = Encode(&Person{Name: "Bob"})
+	require.NoError(t, err)
+	require.NoError(t, DecodeWithOptions(buf, &out, DecodeOptions{Strict: true}))
+}
+
+type requiredPtr struct {
+	A *uint32 `protobuf:"req"`
+	B *uint32
+}
+
+type strictOuter struct {
+	Inner  requiredPtr
+	Nested map[string]*requiredPtr
+}
+
+type requiredPtrV1 struct {
+	_ struct{}
+	B *uint32
+}
+
+type strictOuterV1 struct {
+	Inner  requiredPtrV1
+	Nested map[string]*requiredPtrV1
+}
+
+func TestStrictDecodeNested(t *testing.T) {
+	one := uint32(1)
+	buf, err := Encode(&strictOuterV1{
+		Inner:  requiredPtrV1{B: &one},
+		Nested: map[string]*requiredPtrV1{"k": {}},
+	})
+	require.NoError(t, err)
+
+	var out strictOuter
+	err = DecodeWithOptions(buf, &out, DecodeOptions{Strict: true})
+	require.Error(t, err)
+	assert.Equal(t, []string{
+		"strictOuter.inner.a",
+		"strictOuter.nested[k].a",
+	}, err.(*RequiredFieldsError).Fields)
+}
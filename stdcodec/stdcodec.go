@@ -0,0 +1,310 @@
+// Package stdcodec provides protobuf codecs for standard library types
+// that the protobuf package cannot encode by itself,
+// or only in a lossy way.
+//
+// Each codec has a fixed wire form, so that values written by one
+// version of a program can be read by any other:
+//
+//	Type        .proto  Wire form
+//	big.Int     bytes   sign byte (0 or 1 if negative), then the big-endian
+//	                    absolute value without leading zero bytes
+//	big.Float   string  hexadecimal floating-point literal, as in C99,
+//	                    such as "-0x1.8p+00", or "+Inf" or "-Inf"
+//	net.IP      bytes   4 bytes for IPv4 addresses, including IPv4-mapped
+//	                    IPv6 addresses, 16 bytes otherwise, empty for nil
+//	net.IPNet   bytes   address followed by the mask, both 4 or 16 bytes
+//	url.URL     string  the URL as returned by URL.String
+//	time.Time   string  RFC 3339 time with nanoseconds, then, unless empty,
+//	                    a space and the name of the time's Location
+//	UUID        string  canonical lower-case form, such as
+//	                    "123e4567-e89b-12d3-a456-426614174000"
+//
+// The codecs for struct types also handle pointers to them.
+// Register adds all but the UUID codec, which is for types of the caller's
+// choosing, to a set of codecs:
+//
+//	stdcodec.Register(protobuf.DefaultCodecs)
+//	protobuf.RegisterCodec(reflect.TypeOf(MyUUID{}), stdcodec.UUID(reflect.TypeOf(MyUUID{})))
+//
+// Note that registering the time.Time codec replaces the default sfixed64
+// encoding of times, which cannot hold the zone they are in.
+package stdcodec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.dedis.ch/protobuf"
+)
+
+// Codecs for standard library types, registered by Register.
+var (
+	BigInt   protobuf.Codec = bigIntCodec{}
+	BigFloat protobuf.Codec = bigFloatCodec{}
+	IP       protobuf.Codec = ipCodec{}
+	IPNet    protobuf.Codec = ipNetCodec{}
+	URL      protobuf.Codec = urlCodec{}
+	Time     protobuf.Codec = timeCodec{}
+)
+
+// Register adds the codecs for big.Int, big.Float, net.IP, net.IPNet,
+// url.URL and time.Time to cs.
+func Register(cs *protobuf.Codecs) {
+	cs.Register(reflect.TypeOf(big.Int{}), BigInt)
+	cs.Register(reflect.TypeOf(big.Float{}), BigFloat)
+	cs.Register(reflect.TypeOf(net.IP{}), IP)
+	cs.Register(reflect.TypeOf(net.IPNet{}), IPNet)
+	cs.Register(reflect.TypeOf(url.URL{}), URL)
+	cs.Register(reflect.TypeOf(time.Time{}), Time)
+}
+
+type bigIntCodec struct{}
+
+func (bigIntCodec) Encode(v interface{}) ([]byte, error) {
+	i := v.(big.Int)
+	sign := byte(0)
+	if i.Sign() < 0 {
+		sign = 1
+	}
+	return append([]byte{sign}, i.Bytes()...), nil
+}
+
+func (bigIntCodec) Decode(buf []byte) (interface{}, error) {
+	if len(buf) == 0 || buf[0] > 1 {
+		return nil, errors.New("bad big.Int sign")
+	}
+	mag := buf[1:]
+	if len(mag) > 0 && mag[0] == 0 {
+		return nil, errors.New("big.Int has leading zeros")
+	}
+	if buf[0] == 1 && len(mag) == 0 {
+		return nil, errors.New("big.Int is negative zero")
+	}
+	var i big.Int
+	i.SetBytes(mag)
+	if buf[0] == 1 {
+		i.Neg(&i)
+	}
+	return i, nil
+}
+
+func (bigIntCodec) ProtoType() string {
+	return "bytes"
+}
+
+type bigFloatCodec struct{}
+
+func (bigFloatCodec) Encode(v interface{}) ([]byte, error) {
+	f := v.(big.Float)
+	return []byte(f.Text('x', -1)), nil
+}
+
+// Decode returns a value exactly equal to the encoded one,
+// with a precision of at least 64 bits.
+func (bigFloatCodec) Decode(buf []byte) (interface{}, error) {
+	s := string(buf)
+	digits := 0
+	if i := strings.Index(s, "0x"); i >= 0 {
+		mant := s[i+2:]
+		if p := strings.IndexByte(mant, 'p'); p >= 0 {
+			mant = mant[:p]
+		}
+		digits = len(mant) - strings.Count(mant, ".")
+	} else if !strings.HasSuffix(s, "Inf") {
+		return nil, fmt.Errorf("bad big.Float %q", s)
+	}
+	prec := uint(4 * digits)
+	if prec < 64 {
+		prec = 64
+	}
+	f, _, err := big.ParseFloat(s, 0, prec, big.ToNearestEven)
+	if err != nil {
+		return nil, err
+	}
+	return *f, nil
+}
+
+func (bigFloatCodec) ProtoType() string {
+	return "string"
+}
+
+type ipCodec struct{}
+
+func (ipCodec) Encode(v interface{}) ([]byte, error) {
+	ip := v.(net.IP)
+	if len(ip) == 0 {
+		return nil, nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4, nil
+	}
+	if len(ip) != net.IPv6len {
+		return nil, fmt.Errorf("bad net.IP length %d", len(ip))
+	}
+	return ip, nil
+}
+
+func (ipCodec) Decode(buf []byte) (interface{}, error) {
+	switch len(buf) {
+	case 0:
+		return net.IP(nil), nil
+	case net.IPv4len, net.IPv6len:
+		return net.IP(append([]byte(nil), buf...)), nil
+	}
+	return nil, fmt.Errorf("bad net.IP length %d", len(buf))
+}
+
+func (ipCodec) ProtoType() string {
+	return "bytes"
+}
+
+type ipNetCodec struct{}
+
+func (ipNetCodec) Encode(v interface{}) ([]byte, error) {
+	n := v.(net.IPNet)
+	var ip net.IP
+	switch len(n.Mask) {
+	case net.IPv4len:
+		ip = n.IP.To4()
+	case net.IPv6len:
+		ip = n.IP.To16()
+	}
+	if ip == nil {
+		return nil, fmt.Errorf("bad net.IPNet %s", &n)
+	}
+	return append(append([]byte(nil), ip...), n.Mask...), nil
+}
+
+func (ipNetCodec) Decode(buf []byte) (interface{}, error) {
+	if len(buf) != 2*net.IPv4len && len(buf) != 2*net.IPv6len {
+		return nil, fmt.Errorf("bad net.IPNet length %d", len(buf))
+	}
+	b := append([]byte(nil), buf...)
+	l := len(b) / 2
+	return net.IPNet{IP: b[:l:l], Mask: b[l:]}, nil
+}
+
+func (ipNetCodec) ProtoType() string {
+	return "bytes"
+}
+
+type urlCodec struct{}
+
+func (urlCodec) Encode(v interface{}) ([]byte, error) {
+	u := v.(url.URL)
+	return []byte(u.String()), nil
+}
+
+func (urlCodec) Decode(buf []byte) (interface{}, error) {
+	u, err := url.Parse(string(buf))
+	if err != nil {
+		return nil, err
+	}
+	return *u, nil
+}
+
+func (urlCodec) ProtoType() string {
+	return "string"
+}
+
+type timeCodec struct{}
+
+// Encode fails for years outside the range 0 to 9999,
+// which RFC 3339 cannot express.
+func (timeCodec) Encode(v interface{}) ([]byte, error) {
+	t := v.(time.Time)
+	b, err := t.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	if name := t.Location().String(); name != "" {
+		b = append(append(b, ' '), name...)
+	}
+	return b, nil
+}
+
+// Decode restores the Location by name if it is known on this system,
+// and otherwise uses a fixed zone with the same name and offset.
+func (timeCodec) Decode(buf []byte) (interface{}, error) {
+	text, name := buf, ""
+	if i := bytes.IndexByte(buf, ' '); i >= 0 {
+		text, name = buf[:i], string(buf[i+1:])
+	}
+	var t time.Time
+	if err := t.UnmarshalText(text); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return t, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		_, offset := t.Zone()
+		loc = time.FixedZone(name, offset)
+	}
+	return t.In(loc), nil
+}
+
+func (timeCodec) ProtoType() string {
+	return "string"
+}
+
+// UUID returns a codec for t, a [16]byte array type holding UUIDs.
+// It panics if t is another type.
+func UUID(t reflect.Type) protobuf.Codec {
+	if t.Kind() != reflect.Array || t.Len() != 16 || t.Elem().Kind() != reflect.Uint8 {
+		panic("stdcodec: UUID of non-[16]byte type " + t.String())
+	}
+	return uuidCodec{t}
+}
+
+type uuidCodec struct {
+	typ reflect.Type
+}
+
+func (c uuidCodec) Encode(v interface{}) ([]byte, error) {
+	var u [16]byte
+	reflect.Copy(reflect.ValueOf(u[:]), reflect.ValueOf(v))
+	buf := make([]byte, 36)
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return buf, nil
+}
+
+func (c uuidCodec) Decode(buf []byte) (interface{}, error) {
+	if len(buf) != 36 || buf[8] != '-' || buf[13] != '-' || buf[18] != '-' || buf[23] != '-' {
+		return nil, fmt.Errorf("bad UUID %q", buf)
+	}
+	digits := make([]byte, 0, 32)
+	digits = append(digits, buf[0:8]...)
+	digits = append(digits, buf[9:13]...)
+	digits = append(digits, buf[14:18]...)
+	digits = append(digits, buf[19:23]...)
+	digits = append(digits, buf[24:]...)
+	var u [16]byte
+	if _, err := hex.Decode(u[:], digits); err != nil {
+		return nil, fmt.Errorf("bad UUID %q", buf)
+	}
+	v := reflect.New(c.typ).Elem()
+	reflect.Copy(v, reflect.ValueOf(u[:]))
+	return v.Interface(), nil
+}
+
+func (c uuidCodec) ProtoType() string {
+	return "string"
+}
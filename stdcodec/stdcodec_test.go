@@ -0,0 +1,255 @@
+package stdcodec
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+)
+
+type testUUID [16]byte
+
+type stdTypes struct {
+	I     *big.Int
+	F     *big.Float
+	IP    net.IP
+	Net   *net.IPNet
+	URL   *url.URL
+	T     time.Time
+	ID    testUUID
+	Is    []*big.Int
+	Addrs []net.IP
+}
+
+func testCodecs() *protobuf.Codecs {
+	cs := protobuf.NewCodecs()
+	Register(cs)
+	cs.Register(reflect.TypeOf(testUUID{}), UUID(reflect.TypeOf(testUUID{})))
+	return cs
+}
+
+func roundTrip(t *testing.T, in interface{}, out interface{}) []byte {
+	cs := testCodecs()
+	buf, err := protobuf.EncodeWithOptions(in, protobuf.EncodeOptions{Codecs: cs})
+	require.NoError(t, err)
+	require.NoError(t, protobuf.DecodeWithOptions(buf, out, protobuf.DecodeOptions{Codecs: cs}))
+	return buf
+}
+
+func TestStdTypesRoundTrip(t *testing.T) {
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	if err != nil {
+		zurich = time.FixedZone("CET", 3600)
+	}
+	_, ipNet, err := net.ParseCIDR("2001:db8::/32")
+	require.NoError(t, err)
+	u, err := url.Parse("https://user@example.com:8080/a%2Fb?q=1#frag")
+	require.NoError(t, err)
+	f, _, err := big.ParseFloat("-0.1", 10, 200, big.ToNearestEven)
+	require.NoError(t, err)
+	n, _ := new(big.Int).SetString("-340282366920938463463374607431768211457", 10)
+
+	in := stdTypes{
+		I:     n,
+		F:     f,
+		IP:    net.ParseIP("2001:db8::1"),
+		Net:   ipNet,
+		URL:   u,
+		T:     time.Date(2019, 3, 31, 2, 30, 0, 123456789, zurich),
+		ID:    testUUID{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00},
+		Is:    []*big.Int{big.NewInt(0), big.NewInt(-1), big.NewInt(256)},
+		Addrs: []net.IP{net.ParseIP("192.0.2.1"), net.IPv6loopback},
+	}
+	var out stdTypes
+	roundTrip(t, &in, &out)
+
+	assert.Equal(t, 0, in.I.Cmp(out.I))
+	assert.Equal(t, 0, in.F.Cmp(out.F))
+	assert.Equal(t, in.IP, out.IP)
+	assert.Equal(t, in.Net, out.Net)
+	assert.Equal(t, in.URL.String(), out.URL.String())
+	assert.True(t, in.T.Equal(out.T))
+	assert.Equal(t, in.T.Location().String(), out.T.Location().String())
+	assert.Equal(t, in.ID, out.ID)
+	require.Len(t, out.Is, 3)
+	for i := range in.Is {
+		assert.Equal(t, 0, in.Is[i].Cmp(out.Is[i]))
+	}
+	require.Len(t, out.Addrs, 2)
+	for i := range in.Addrs {
+		assert.True(t, in.Addrs[i].Equal(out.Addrs[i]))
+	}
+}
+
+func TestBigIntWireForm(t *testing.T) {
+	for _, c := range []struct {
+		i   int64
+		enc []byte
+	}{
+		{0, []byte{0}},
+		{1, []byte{0, 1}},
+		{-1, []byte{1, 1}},
+		{-256, []byte{1, 1, 0}},
+		{math.MinInt64, []byte{1, 0x80, 0, 0, 0, 0, 0, 0, 0}},
+	} {
+		b, err := BigInt.Encode(*big.NewInt(c.i))
+		require.NoError(t, err)
+		assert.Equal(t, c.enc, b)
+		v, err := BigInt.Decode(b)
+		require.NoError(t, err)
+		i := v.(big.Int)
+		assert.Equal(t, c.i, i.Int64())
+	}
+
+	for _, bad := range [][]byte{nil, {2}, {1}, {0, 0, 1}} {
+		_, err := BigInt.Decode(bad)
+		assert.Error(t, err, "%x", bad)
+	}
+}
+
+func TestBigFloatWireForm(t *testing.T) {
+	b, err := BigFloat.Encode(*big.NewFloat(1.5))
+	require.NoError(t, err)
+	assert.Equal(t, "0x1.8p+00", string(b))
+
+	for _, f := range []*big.Float{
+		big.NewFloat(0),
+		big.NewFloat(math.Copysign(0, -1)),
+		new(big.Float).SetInf(false),
+		new(big.Float).SetInf(true),
+		new(big.Float).SetPrec(1000).Quo(big.NewFloat(1), big.NewFloat(3)),
+	} {
+		b, err := BigFloat.Encode(*f)
+		require.NoError(t, err)
+		v, err := BigFloat.Decode(b)
+		require.NoError(t, err)
+		g := v.(big.Float)
+		assert.Equal(t, 0, f.Cmp(&g), "%s", b)
+		assert.Equal(t, f.Signbit(), g.Signbit(), "%s", b)
+	}
+
+	_, err = BigFloat.Decode([]byte("1.5"))
+	assert.Error(t, err)
+}
+
+func TestIPWireForm(t *testing.T) {
+	// IPv4 addresses, even in their 16-byte form, take 4 bytes.
+	for _, ip := range []net.IP{net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 1).To4()} {
+		b, err := IP.Encode(ip)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{192, 0, 2, 1}, b)
+	}
+	mapped := net.ParseIP("::ffff:192.0.2.1")
+	var in, out struct{ IP net.IP }
+	in.IP = mapped
+	roundTrip(t, &in, &out)
+	assert.True(t, mapped.Equal(out.IP))
+	assert.Len(t, out.IP, net.IPv4len)
+
+	b, err := IP.Encode(net.IPv6loopback)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(net.IPv6loopback), b)
+
+	// A nil address stays nil.
+	in.IP = nil
+	out.IP = net.IPv6loopback
+	roundTrip(t, &in, &out)
+	assert.Nil(t, out.IP)
+
+	_, err = IP.Encode(net.IP{1, 2, 3})
+	assert.Error(t, err)
+	_, err = IP.Decode([]byte{1, 2, 3})
+	assert.Error(t, err)
+}
+
+func TestIPNetWireForm(t *testing.T) {
+	_, n, err := net.ParseCIDR("192.0.2.0/24")
+	require.NoError(t, err)
+	b, err := IPNet.Encode(*n)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{192, 0, 2, 0, 255, 255, 255, 0}, b)
+	v, err := IPNet.Decode(b)
+	require.NoError(t, err)
+	assert.Equal(t, *n, v)
+
+	// Non-canonical masks survive.
+	odd := net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.IPMask{255, 0, 255, 0}}
+	b, err = IPNet.Encode(odd)
+	require.NoError(t, err)
+	v, err = IPNet.Decode(b)
+	require.NoError(t, err)
+	assert.Equal(t, odd, v)
+
+	_, err = IPNet.Encode(net.IPNet{IP: net.IPv6loopback, Mask: net.CIDRMask(8, 32)})
+	assert.Error(t, err)
+	_, err = IPNet.Decode(b[:7])
+	assert.Error(t, err)
+}
+
+func TestTimeWireForm(t *testing.T) {
+	tm := time.Date(2000, 1, 2, 3, 4, 5, 6, time.FixedZone("XYZ", -5*3600))
+	b, err := Time.Encode(tm)
+	require.NoError(t, err)
+	assert.Equal(t, "2000-01-02T03:04:05.000000006-05:00 XYZ", string(b))
+	v, err := Time.Decode(b)
+	require.NoError(t, err)
+	assert.Equal(t, tm, v)
+
+	// The zero time, which the default encoding gets wrong.
+	var in, out struct{ T time.Time }
+	out.T = time.Now()
+	roundTrip(t, &in, &out)
+	assert.True(t, out.T.IsZero())
+	assert.Equal(t, time.UTC, out.T.Location())
+
+	_, err = Time.Encode(time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Error(t, err)
+}
+
+func TestUUIDWireForm(t *testing.T) {
+	c := UUID(reflect.TypeOf(testUUID{}))
+	id := testUUID{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00}
+	b, err := c.Encode(id)
+	require.NoError(t, err)
+	assert.Equal(t, "123e4567-e89b-12d3-a456-426614174000", string(b))
+
+	v, err := c.Decode(bytes.ToUpper(b))
+	require.NoError(t, err)
+	assert.Equal(t, id, v)
+
+	for _, bad := range []string{"", "123e4567e89b12d3a456426614174000", "123e4567-e89b-12d3-a456-42661417400g"} {
+		_, err := c.Decode([]byte(bad))
+		assert.Error(t, err, bad)
+	}
+	assert.Panics(t, func() { UUID(reflect.TypeOf([8]byte{})) })
+}
+
+func TestGenerate(t *testing.T) {
+	w := &bytes.Buffer{}
+	opts := protobuf.GenerateOptions{Codecs: testCodecs()}
+	err := protobuf.GenerateWithOptions(w, []interface{}{stdTypes{}}, nil, nil, opts)
+	require.NoError(t, err)
+	expected := `
+message stdTypes {
+  optional bytes i = 1;
+  optional string f = 2;
+  required bytes ip = 3;
+  optional bytes net = 4;
+  optional string url = 5;
+  required string t = 6;
+  required string id = 7;
+  repeated bytes is = 8;
+  repeated bytes addrs = 9;
+}
+
+`
+	assert.Equal(t, expected, w.String())
+}
@@ -0,0 +1,141 @@
+package protobuf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// DefaultMaxFrameSize is the largest message, in bytes, that an Encoder
+// will write or a Decoder will read unless configured otherwise.
+const DefaultMaxFrameSize = 64 << 20
+
+// ErrFrameTooLarge is returned by Encoder.Encode and Decoder.Decode
+// when a message exceeds the configured maximum frame size.
+var ErrFrameTooLarge = errors.New("protobuf: frame exceeds maximum size")
+
+// Encoder writes a stream of length-delimited messages to an io.Writer.
+// Each message is preceded by its length encoded as a varint,
+// which is the framing used by writeDelimitedTo in other
+// Protocol Buffers implementations.
+type Encoder struct {
+	w       io.Writer
+	opts    *EncodeOptions
+	maxSize int
+}
+
+// NewEncoder returns an Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, maxSize: DefaultMaxFrameSize}
+}
+
+// SetMaxFrameSize sets the largest message, in bytes, that Encode will write.
+// A value of zero or less disables the limit.
+func (e *Encoder) SetMaxFrameSize(n int) {
+	e.maxSize = n
+}
+
+// SetOptions makes Encode use opts instead of DefaultEncodeOptions.
+func (e *Encoder) SetOptions(opts EncodeOptions) {
+	e.opts = &opts
+}
+
+// Encode encodes the struct pointed to by structPtr
+// and writes it to the stream as a single length-delimited frame.
+func (e *Encoder) Encode(structPtr interface{}) error {
+	opts := DefaultEncodeOptions
+	if e.opts != nil {
+		opts = *e.opts
+	}
+	buf, err := EncodeWithOptions(structPtr, opts)
+	if err != nil {
+		return err
+	}
+	if e.maxSize > 0 && len(buf) > e.maxSize {
+		return ErrFrameTooLarge
+	}
+
+	// Write the length prefix and the message in one call,
+	// so that concurrent writers to w can't interleave a frame.
+	frame := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(buf))
+	n := binary.PutUvarint(frame, uint64(len(buf)))
+	frame = append(frame[:n], buf...)
+	_, err = e.w.Write(frame)
+	return err
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// Decoder reads a stream of length-delimited messages from an io.Reader,
+// as written by Encoder or by parseDelimitedFrom-compatible peers.
+// The Decoder may buffer data from the underlying reader
+// beyond the last message it returned.
+type Decoder struct {
+	r       byteReader
+	opts    DecodeOptions
+	maxSize int
+}
+
+// NewDecoder returns a Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return NewDecoderWithConstructors(r, nil)
+}
+
+// NewDecoderWithConstructors is like NewDecoder, but the messages read
+// are decoded as with DecodeWithConstructors using cons.
+func NewDecoderWithConstructors(r io.Reader, cons Constructors) *Decoder {
+	br, ok := r.(byteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Decoder{
+		r:       br,
+		opts:    DecodeOptions{Constructors: cons},
+		maxSize: DefaultMaxFrameSize,
+	}
+}
+
+// SetOptions makes Decode decode messages as DecodeWithOptions does
+// with opts, replacing any constructors given to the Decoder before.
+func (d *Decoder) SetOptions(opts DecodeOptions) {
+	d.opts = opts
+}
+
+// SetMaxFrameSize sets the largest message, in bytes, that Decode will read.
+// A value of zero or less disables the limit.
+func (d *Decoder) SetMaxFrameSize(n int) {
+	d.maxSize = n
+}
+
+// Decode reads the next length-delimited frame from the stream
+// and decodes it into the struct pointed to by structPtr.
+// It returns io.EOF if the stream ends cleanly before a new frame,
+// and io.ErrUnexpectedEOF if it ends in the middle of one.
+// After ErrFrameTooLarge the position in the stream is undefined.
+func (d *Decoder) Decode(structPtr interface{}) error {
+	size, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return err
+	}
+	if d.maxSize > 0 && size > uint64(d.maxSize) || size > math.MaxInt64 {
+		return ErrFrameTooLarge
+	}
+
+	// Decoded byte slices refer to the frame buffer,
+	// so every frame gets a fresh one. It grows as data arrives,
+	// rather than trusting the length prefix, which may be bogus.
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(io.LimitReader(d.r, int64(size))); err != nil {
+		return err
+	}
+	if uint64(buf.Len()) < size {
+		return io.ErrUnexpectedEOF
+	}
+	return DecodeWithOptions(buf.Bytes(), structPtr, d.opts)
+}
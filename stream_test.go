@@ -0,0 +1,99 @@
+package protobuf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamRoundTrip(t *testing.T) {
+	email := "alice@somewhere"
+	people := []Person{
+		{Name: "Alice", Id: 123, Email: &email},
+		{Name: "Bob", Id: -7, Phone: []PhoneNumber{{Number: "111-222-3333"}}},
+		{},
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for i := range people {
+		require.NoError(t, enc.Encode(&people[i]))
+	}
+
+	dec := NewDecoder(&buf)
+	for i := range people {
+		var p Person
+		require.NoError(t, dec.Decode(&p))
+		assert.Equal(t, people[i], p)
+	}
+	var p Person
+	assert.Equal(t, io.EOF, dec.Decode(&p))
+}
+
+func TestStreamFraming(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, NewEncoder(&buf).Encode(&Test1{150}))
+	assert.Equal(t, []byte{3, 0x08, 0x96, 0x01}, buf.Bytes())
+}
+
+func TestStreamTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, NewEncoder(&buf).Encode(&Test1{150}))
+	full := buf.Bytes()
+
+	var t1 Test1
+	err := NewDecoder(bytes.NewReader(full[:len(full)-1])).Decode(&t1)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	err = NewDecoder(bytes.NewReader([]byte{0x80})).Decode(&t1)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// Without a limit, a huge length prefix doesn't allocate
+	// more than the data that follows it.
+	prefix := make([]byte, binary.MaxVarintLen64)
+	frame := append(prefix[:binary.PutUvarint(prefix, 1<<40)], 0x08, 0x01)
+	dec := NewDecoder(bytes.NewReader(frame))
+	dec.SetMaxFrameSize(0)
+	assert.Equal(t, io.ErrUnexpectedEOF, dec.Decode(&t1))
+}
+
+func TestStreamMaxFrameSize(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetMaxFrameSize(2)
+	assert.Equal(t, ErrFrameTooLarge, enc.Encode(&Test1{150}))
+	assert.Equal(t, 0, buf.Len())
+
+	enc.SetMaxFrameSize(0)
+	require.NoError(t, enc.Encode(&Test1{150}))
+
+	dec := NewDecoder(bytes.NewReader(buf.Bytes()))
+	dec.SetMaxFrameSize(2)
+	var t1 Test1
+	assert.Equal(t, ErrFrameTooLarge, dec.Decode(&t1))
+}
+
+func TestStreamConstructors(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, enc.Encode(&Wrapper{NewNumber(i)}))
+	}
+
+	cons := Constructors{
+		reflect.TypeOf((*Number)(nil)).Elem(): func() interface{} { return &Int{} },
+	}
+	dec := NewDecoderWithConstructors(&buf, cons)
+	for i := 1; i <= 3; i++ {
+		var w Wrapper
+		require.NoError(t, dec.Decode(&w))
+		assert.Equal(t, i, w.N.Value())
+	}
+	var w Wrapper
+	assert.Equal(t, io.EOF, dec.Decode(&w))
+}
@@ -0,0 +1,118 @@
+package protobuf
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tagEncoded struct {
+	U64   uint64   `protobuf:"fixed"`
+	I32   int32    `protobuf:"fixed"`
+	V32   int32    `protobuf:"varint"`
+	V64   int64    `protobuf:"varint"`
+	Z64   Int64    `protobuf:"zigzag"`
+	OU32  *uint32  `protobuf:"fixed"`
+	SI64  []int64  `protobuf:"fixed"`
+	SU32  []uint32 `protobuf:"fixed,packed=false"`
+	Text  []byte   `protobuf:"string"`
+	Named int      `protobuf:"10,opt,varint,total"`
+}
+
+func TestTagEncodingWireFormat(t *testing.T) {
+	o := uint32(2)
+	in := tagEncoded{
+		U64:  1,
+		I32:  -1,
+		V32:  -1,
+		V64:  150,
+		Z64:  -1,
+		OU32: &o,
+		SI64: []int64{-1},
+		SU32: []uint32{3, 4},
+		Text: []byte("hi"),
+	}
+	buf, err := Encode(&in)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x09, 1, 0, 0, 0, 0, 0, 0, 0,
+		0x15, 0xff, 0xff, 0xff, 0xff,
+		0x18, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01,
+		0x20, 0x96, 0x01,
+		0x28, 0x01,
+		0x35, 2, 0, 0, 0,
+		0x3a, 0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0x45, 3, 0, 0, 0, 0x45, 4, 0, 0, 0,
+		0x4a, 0x02, 'h', 'i',
+		0x50, 0x00,
+	}, buf)
+
+	var out tagEncoded
+	require.NoError(t, Decode(buf, &out))
+	assert.Equal(t, in, out)
+}
+
+func TestTagEncodingVarintRange(t *testing.T) {
+	// 2^31 doesn't fit in the int32 field v32.
+	var out tagEncoded
+	err := Decode([]byte{0x18, 0x80, 0x80, 0x80, 0x80, 0x08}, &out)
+	assert.Error(t, err)
+}
+
+func TestParseTagSkipsOptions(t *testing.T) {
+	f, _ := reflect.TypeOf(tagEncoded{}).FieldByName("Named")
+	id, opt, name := ParseTag(f)
+	assert.Equal(t, 10, id)
+	assert.Equal(t, TagOptional, opt)
+	assert.Equal(t, "total", name)
+	enc, err := ParseTagEncoding(f)
+	require.NoError(t, err)
+	assert.Equal(t, EncodingVarint, enc)
+}
+
+func TestTagEncodingInvalid(t *testing.T) {
+	for _, v := range []interface{}{
+		struct {
+			S string `protobuf:"fixed"`
+		}{},
+		struct {
+			U uint32 `protobuf:"zigzag"`
+		}{},
+		struct {
+			I int64 `protobuf:"fixed,varint"`
+		}{},
+		struct {
+			M map[int32]int32 `protobuf:"fixed"`
+		}{},
+	} {
+		f := reflect.TypeOf(v).Field(0)
+		_, err := ParseTagEncoding(f)
+		assert.Error(t, err, "%s", f.Tag)
+		assert.Panics(t, func() { ProtoFields(reflect.TypeOf(v)) })
+	}
+}
+
+func TestGenerateTagEncoding(t *testing.T) {
+	w := &bytes.Buffer{}
+	err := GenerateProtobufDefinition(w, []interface{}{tagEncoded{}}, nil, nil)
+	require.NoError(t, err)
+	expected := `
+message tagEncoded {
+  required fixed64 u64 = 1;
+  required sfixed32 i32 = 2;
+  required int32 v32 = 3;
+  required int64 v64 = 4;
+  required sint64 z64 = 5;
+  optional fixed32 ou32 = 6;
+  repeated sfixed64 si64 = 7 [packed=true];
+  repeated fixed32 su32 = 8;
+  required string text = 9;
+  optional int64 total = 10;
+}
+
+`
+	assert.Equal(t, expected, w.String())
+}
@@ -0,0 +1,118 @@
+package protobuf
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type peerInnerV2 struct {
+	X int64
+	Y string
+}
+
+type peerV2 struct {
+	A     uint32
+	B     string
+	C     []uint64
+	Inner peerInnerV2
+	M     map[string]uint32
+}
+
+type peerInnerV1 struct {
+	X       int64
+	Unknown UnknownFields
+}
+
+type peerV1 struct {
+	A uint32
+	UnknownFields
+	_     string   // = 2, deleted
+	_     []uint64 // = 3, deleted
+	Inner peerInnerV1
+}
+
+func TestUnknownFieldsRoundTrip(t *testing.T) {
+	in := peerV2{
+		A:     1,
+		B:     "newer",
+		C:     []uint64{1, 2, 3},
+		Inner: peerInnerV2{X: -4, Y: "nested"},
+		M:     map[string]uint32{"k": 5},
+	}
+	buf, err := Encode(&in)
+	require.NoError(t, err)
+
+	// Fields 2 and 3 are blank in peerV1, which discards them,
+	// while fields 5 and 4.2 are unknown and kept.
+	var old peerV1
+	require.NoError(t, Decode(buf, &old))
+	assert.Equal(t, uint32(1), old.A)
+	assert.Equal(t, int64(-4), old.Inner.X)
+	assert.NotEmpty(t, old.UnknownFields)
+	assert.NotEmpty(t, old.Inner.Unknown)
+
+	relayed, err := Encode(&old)
+	require.NoError(t, err)
+
+	var out peerV2
+	require.NoError(t, Decode(relayed, &out))
+	assert.Equal(t, peerV2{
+		A:     1,
+		Inner: peerInnerV2{X: -4, Y: "nested"},
+		M:     map[string]uint32{"k": 5},
+	}, out)
+}
+
+type relayAll struct {
+	Unknown UnknownFields
+}
+
+func TestUnknownFieldsOnly(t *testing.T) {
+	in := peerV2{A: 7, B: "b", C: []uint64{9}}
+	buf, err := Encode(&in)
+	require.NoError(t, err)
+
+	var r relayAll
+	require.NoError(t, Decode(buf, &r))
+	assert.Equal(t, UnknownFields(buf), r.Unknown)
+
+	relayed, err := Encode(&r)
+	require.NoError(t, err)
+	assert.Equal(t, buf, relayed)
+
+	// Decoding again replaces the previous content.
+	buf, err = Encode(&peerV2{A: 8})
+	require.NoError(t, err)
+	require.NoError(t, Decode(buf, &r))
+	assert.Equal(t, UnknownFields(buf), r.Unknown)
+}
+
+type unknownNumbering struct {
+	A uint32
+	U UnknownFields
+	B string
+}
+
+func TestUnknownFieldsNumbering(t *testing.T) {
+	fields := ProtoFields(reflect.TypeOf(unknownNumbering{}))
+	require.Len(t, fields, 2)
+	assert.Equal(t, int64(1), fields[0].ID)
+	assert.Equal(t, int64(2), fields[1].ID)
+}
+
+func TestUnknownFieldsUnexported(t *testing.T) {
+	type hidden struct {
+		A       uint32
+		unknown UnknownFields
+	}
+	buf, err := Encode(&peerV2{A: 1, B: "b"})
+	require.NoError(t, err)
+	var out hidden
+	err = Decode(buf, &out)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "must be exported")
+	}
+}
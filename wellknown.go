@@ -0,0 +1,184 @@
+package protobuf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// The well-known types of protobuf, as far as this package supports them.
+//
+// With the "wkt" tag option, or the WellKnownTypes encoding option,
+// time.Time values are sent as google.protobuf.Timestamp messages
+// and time.Duration values as google.protobuf.Duration messages:
+//
+//	message Timestamp { int64 seconds = 1; int32 nanos = 2; }
+//	message Duration { int64 seconds = 1; int32 nanos = 2; }
+//
+// Unlike the default encodings as nanoseconds since 1970, Timestamps
+// cover all the years from 1 to 9999, including the zero time.Time.
+//
+// Singular time.Time and time.Duration fields are decoded in either form.
+// Repeated time.Duration fields can't be: Duration messages and packed
+// integers are both length-delimited, and may look alike, so a decoder
+// guessing wrong would get wrong values rather than an error.
+// They are therefore sent as messages only with the "wkt" tag option,
+// which both ends share, and never because of the WellKnownTypes options.
+
+const (
+	timestampProto = "google/protobuf/timestamp.proto"
+	durationProto  = "google/protobuf/duration.proto"
+
+	// Seconds from 1970 to the years 1 and 10000, the range of Timestamps.
+	minTimestamp = -62135596800
+	maxTimestamp = 253402300800
+)
+
+// checkWellKnown returns an error if field is tagged "wkt"
+// but its type has no well-known equivalent.
+// Repeated fields and maps of well-known types may be tagged as well,
+// and so may optional scalars, which are wrapped.
+func checkWellKnown(field reflect.StructField, enc TagEncoding) error {
+	if !hasTagOption(field, "wkt") {
+		return nil
+	}
+	if wrapperName(field.Type) != "" && enc == EncodingDefault {
+		return nil
+	}
+	t := typeIndirect(field.Type)
+	if dynamicTypeName(t) != "" && enc == EncodingDefault {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Map:
+		t = typeIndirect(t.Elem())
+	}
+	if t != timeType && t != durationType && dynamicTypeName(t) == "" || enc != EncodingDefault {
+		return fmt.Errorf("wkt option of field %s does not apply to %s", field.Name, field.Type)
+	}
+	return nil
+}
+
+// durationMessages reports whether the elements of the repeated
+// time.Duration field f are sent as google.protobuf.Duration messages.
+func durationMessages(f *ProtoField) bool {
+	return f != nil && f.WellKnown
+}
+
+// wellKnown reports whether the current field is sent as a well-known type.
+func (en *encoder) wellKnown() bool {
+	f := en.path.field()
+	return en.opts.WellKnownTypes || f != nil && f.WellKnown
+}
+
+// wellKnown reports whether the current field is sent as a well-known type.
+func (de *decoder) wellKnown() bool {
+	f := de.path.field()
+	return de.opts.WellKnownTypes || f != nil && f.WellKnown
+}
+
+// timestamp writes t with key as a google.protobuf.Timestamp.
+func (en *encoder) timestamp(key uint64, t time.Time) error {
+	sec := t.Unix()
+	if sec < minTimestamp || sec >= maxTimestamp {
+		return fmt.Errorf("time %v out of Timestamp range", t)
+	}
+	en.secondsNanos(key, sec, int32(t.Nanosecond()))
+	return nil
+}
+
+// duration writes d with key as a google.protobuf.Duration.
+func (en *encoder) duration(key uint64, d time.Duration) {
+	en.secondsNanos(key, int64(d/time.Second), int32(d%time.Second))
+}
+
+// secondsNanos writes a Timestamp or Duration message with key.
+// Like proto3 fields, zero fields are omitted.
+func (en *encoder) secondsNanos(key uint64, sec int64, nanos int32) {
+	msg := encoder{}
+	if sec != 0 {
+		msg.uvarint(1<<3 | 0)
+		msg.uvarint(uint64(sec))
+	}
+	if nanos != 0 {
+		msg.uvarint(2<<3 | 0)
+		msg.uvarint(uint64(int64(nanos)))
+	}
+	en.uvarint(key | 2)
+	en.uvarint(uint64(msg.Len()))
+	en.Write(msg.Bytes())
+}
+
+// parseSecondsNanos reads a Timestamp or Duration message.
+func parseSecondsNanos(vb []byte) (sec int64, nanos int32, err error) {
+	for len(vb) > 0 {
+		key, n := binary.Uvarint(vb)
+		if n <= 0 {
+			return 0, 0, errors.New("bad protobuf field key")
+		}
+		vb = vb[n:]
+		wiretype := int(key & 7)
+		if wiretype != 0 || key>>3 != 1 && key>>3 != 2 {
+			// Skip unknown fields.
+			var skip decoder
+			vb, err = skip.value(wiretype, vb, 0, reflect.Value{})
+			if err != nil {
+				return 0, 0, err
+			}
+			continue
+		}
+		v, n := binary.Uvarint(vb)
+		if n <= 0 {
+			return 0, 0, errors.New("bad protobuf varint value")
+		}
+		vb = vb[n:]
+		if key>>3 == 1 {
+			sec = int64(v)
+		} else {
+			if int64(v) < math.MinInt32 || int64(v) > math.MaxInt32 {
+				return 0, 0, errors.New("nanos value out of range")
+			}
+			nanos = int32(v)
+		}
+	}
+	return sec, nanos, nil
+}
+
+// putTimestamp sets the time.Time val from a google.protobuf.Timestamp.
+// Times are decoded in UTC, so that the zero time.Time
+// decodes as the zero value.
+func putTimestamp(val reflect.Value, vb []byte) error {
+	sec, nanos, err := parseSecondsNanos(vb)
+	if err != nil {
+		return err
+	}
+	if sec < minTimestamp || sec >= maxTimestamp || nanos < 0 || nanos >= 1e9 {
+		return errors.New("invalid Timestamp")
+	}
+	val.Set(reflect.ValueOf(time.Unix(sec, int64(nanos)).UTC()))
+	return nil
+}
+
+// putDuration sets the time.Duration val from a google.protobuf.Duration.
+func putDuration(val reflect.Value, vb []byte) error {
+	sec, nanos, err := parseSecondsNanos(vb)
+	if err != nil {
+		return err
+	}
+	if nanos <= -1e9 || nanos >= 1e9 || sec > 0 && nanos < 0 || sec < 0 && nanos > 0 {
+		return errors.New("invalid Duration")
+	}
+	if sec > math.MaxInt64/int64(time.Second) || sec < math.MinInt64/int64(time.Second) {
+		return errors.New("Duration out of range")
+	}
+	d := time.Duration(sec) * time.Second
+	sum := d + time.Duration(nanos)
+	if nanos > 0 && sum < d || nanos < 0 && sum > d {
+		return errors.New("Duration out of range")
+	}
+	val.SetInt(int64(sum))
+	return nil
+}
@@ -0,0 +1,144 @@
+package protobuf
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type wellKnownTimes struct {
+	T  time.Time       `protobuf:"wkt"`
+	D  time.Duration   `protobuf:"wkt"`
+	OT *time.Time      `protobuf:"wkt"`
+	Ds []time.Duration `protobuf:"wkt"`
+	Ts []time.Time     `protobuf:"wkt"`
+}
+
+type plainTimes struct {
+	T  time.Time
+	D  time.Duration
+	Ds []time.Duration
+}
+
+func TestTimestampWireFormat(t *testing.T) {
+	in := wellKnownTimes{
+		T: time.Unix(1, 2).UTC(),
+		D: -1500 * time.Millisecond,
+	}
+	buf, err := Encode(&in)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x0a, 4, 0x08, 1, 0x10, 2,
+		0x12, 22, 0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01,
+		0x10, 0x80, 0xb6, 0xca, 0x91, 0xfe, 0xff, 0xff, 0xff, 0xff, 0x01,
+	}, buf)
+
+	var out wellKnownTimes
+	require.NoError(t, Decode(buf, &out))
+	assert.Equal(t, in, out)
+}
+
+func TestTimestampRoundTrip(t *testing.T) {
+	ot := time.Date(9999, 12, 31, 23, 59, 59, 999999999, time.UTC)
+	in := wellKnownTimes{
+		// The zero time, which doesn't fit in the default encoding.
+		T:  time.Time{},
+		D:  time.Duration(1<<63 - 1),
+		OT: &ot,
+		Ds: []time.Duration{0, time.Nanosecond, -time.Hour},
+		Ts: []time.Time{time.Date(1, 1, 1, 0, 0, 0, 1, time.UTC), time.Unix(0, 0).UTC()},
+	}
+	buf, err := Encode(&in)
+	require.NoError(t, err)
+	var out wellKnownTimes
+	require.NoError(t, Decode(buf, &out))
+	assert.Equal(t, in, out)
+	assert.True(t, out.T.IsZero())
+
+	_, err = Encode(&wellKnownTimes{T: time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC)})
+	assert.Error(t, err)
+}
+
+func TestWellKnownTypesOption(t *testing.T) {
+	in := plainTimes{
+		T:  time.Date(1000, 1, 1, 0, 0, 0, 0, time.UTC),
+		D:  time.Minute,
+		Ds: []time.Duration{time.Second, -time.Second},
+	}
+	buf, err := EncodeWithOptions(&in, EncodeOptions{WellKnownTypes: true})
+	require.NoError(t, err)
+
+	var out plainTimes
+	require.NoError(t, DecodeWithOptions(buf, &out, DecodeOptions{WellKnownTypes: true}))
+	assert.Equal(t, in, out)
+
+	// Singular fields are recognised in either form, and repeated
+	// durations are packed either way, so the options needn't match.
+	out = plainTimes{}
+	require.NoError(t, Decode(buf, &out))
+	assert.Equal(t, in, out)
+
+	buf, err = Encode(&in)
+	require.NoError(t, err)
+	out = plainTimes{}
+	require.NoError(t, DecodeWithOptions(buf, &out, DecodeOptions{WellKnownTypes: true}))
+	assert.Equal(t, in.D, out.D)
+	assert.Equal(t, in.Ds, out.Ds)
+}
+
+func TestInvalidWellKnownTypes(t *testing.T) {
+	var out wellKnownTimes
+	for _, buf := range [][]byte{
+		{0x0a, 2, 0x10, 0xff},                               // truncated nanos
+		{0x0a, 7, 0x10, 0x80, 0x94, 0xeb, 0xdc, 0x03, 0x00}, // nanos of 1e9
+		{0x12, 13, 0x08, 1, 0x10, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, // mixed signs
+		{0x12, 12, 0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 0x10, 0x01, 0x00},
+	} {
+		assert.Error(t, Decode(buf, &out), "%x", buf)
+	}
+
+	assert.Panics(t, func() {
+		ProtoFields(reflect.TypeOf(struct {
+			I int64 `protobuf:"wkt"`
+		}{}))
+	})
+}
+
+func TestGenerateWellKnownTypes(t *testing.T) {
+	w := &bytes.Buffer{}
+	err := GenerateProtobufDefinition(w, []interface{}{wellKnownTimes{}}, nil, nil)
+	require.NoError(t, err)
+	expected := `import "google/protobuf/duration.proto";
+import "google/protobuf/timestamp.proto";
+
+message wellKnownTimes {
+  required google.protobuf.Timestamp t = 1;
+  required google.protobuf.Duration d = 2;
+  optional google.protobuf.Timestamp ot = 3;
+  repeated google.protobuf.Duration ds = 4;
+  repeated google.protobuf.Timestamp ts = 5;
+}
+
+`
+	assert.Equal(t, expected, w.String())
+
+	w.Reset()
+	opts := GenerateOptions{Proto3: true, WellKnownTypes: true}
+	require.NoError(t, GenerateWithOptions(w, []interface{}{plainTimes{}}, nil, nil, opts))
+	expected = `syntax = "proto3";
+import "google/protobuf/duration.proto";
+import "google/protobuf/timestamp.proto";
+
+message plainTimes {
+  google.protobuf.Timestamp t = 1;
+  google.protobuf.Duration d = 2;
+  repeated sint64 ds = 3;
+}
+
+`
+	assert.Equal(t, expected, w.String())
+}
@@ -0,0 +1,189 @@
+package protobuf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// With the "wkt" tag option, or the WellKnownTypes options,
+// optional scalar fields such as *string or *int64 are sent as the
+// matching wrapper message, such as google.protobuf.StringValue
+// or Int64Value, which other implementations use for nullable scalars:
+//
+//	message Int64Value { int64 value = 1; }
+//
+// As in proto3, a zero value is omitted from the wrapper,
+// so a pointer to zero is sent as an empty message.
+// A nil pointer is omitted like any other optional field.
+// Fields with an encoding tag option, or whose pointed-to type
+// has a Codec, are not wrapped.
+
+const wrappersProto = "google/protobuf/wrappers.proto"
+
+// wrapperName returns the well-known wrapper type of pointer type t,
+// or "" if t doesn't point to a scalar.
+func wrapperName(t reflect.Type) string {
+	if t.Kind() != reflect.Ptr || t.Elem() == durationType {
+		return ""
+	}
+	t = t.Elem()
+	switch t.Kind() {
+	case reflect.Bool:
+		return "google.protobuf.BoolValue"
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return "google.protobuf.Int32Value"
+	case reflect.Int, reflect.Int64:
+		return "google.protobuf.Int64Value"
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return "google.protobuf.UInt32Value"
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return "google.protobuf.UInt64Value"
+	case reflect.Float32:
+		return "google.protobuf.FloatValue"
+	case reflect.Float64:
+		return "google.protobuf.DoubleValue"
+	case reflect.String:
+		return "google.protobuf.StringValue"
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "google.protobuf.BytesValue"
+		}
+	}
+	return ""
+}
+
+// wrapped reports whether a pointer of type t, the type of field f,
+// is sent as a wrapper message.
+func (en *encoder) wrapped(t reflect.Type, f *ProtoField) bool {
+	return f != nil && t == f.Field.Type && f.Encoding == EncodingDefault &&
+		en.wellKnown() && wrapperName(t) != "" &&
+		en.codecs().Lookup(t.Elem()) == nil
+}
+
+// wrapped reports whether the pointer val, the value of the current field,
+// is read from a wrapper message.
+func (de *decoder) wrapped(val reflect.Value) bool {
+	f := de.path.field()
+	return f != nil && val.Type() == f.Field.Type && f.Encoding == EncodingDefault &&
+		de.wellKnown() && wrapperName(val.Type()) != "" &&
+		de.codecs().Lookup(val.Type().Elem()) == nil
+}
+
+// wrapper writes the scalar val with key as a wrapper message.
+func (en *encoder) wrapper(key uint64, val reflect.Value) {
+	msg := encoder{}
+	switch val.Kind() {
+	case reflect.Bool:
+		if val.Bool() {
+			msg.uvarint(1<<3 | 0)
+			msg.uvarint(1)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// Two's complement, as the wrappers hold int32 and int64.
+		if v := val.Int(); v != 0 {
+			msg.uvarint(1<<3 | 0)
+			msg.uvarint(uint64(v))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v := val.Uint(); v != 0 {
+			msg.uvarint(1<<3 | 0)
+			msg.uvarint(v)
+		}
+	case reflect.Float32:
+		if v := math.Float32bits(float32(val.Float())); v != 0 {
+			msg.uvarint(1<<3 | 5)
+			msg.u32(v)
+		}
+	case reflect.Float64:
+		if v := math.Float64bits(val.Float()); v != 0 {
+			msg.uvarint(1<<3 | 1)
+			msg.u64(v)
+		}
+	case reflect.String:
+		if v := val.String(); v != "" {
+			msg.uvarint(1<<3 | 2)
+			msg.uvarint(uint64(len(v)))
+			msg.Write([]byte(v))
+		}
+	default:
+		if v := val.Bytes(); len(v) != 0 {
+			msg.uvarint(1<<3 | 2)
+			msg.uvarint(uint64(len(v)))
+			msg.Write(v)
+		}
+	}
+	en.uvarint(key | 2)
+	en.uvarint(uint64(msg.Len()))
+	en.Write(msg.Bytes())
+}
+
+// putWrapper sets the pointer val from the wrapper message vb,
+// instantiating it if it is nil.
+func (de *decoder) putWrapper(val reflect.Value, vb []byte) error {
+	if val.IsNil() {
+		val.Set(reflect.New(val.Type().Elem()))
+	}
+	elem := val.Elem()
+	return dynamicFields(vb, func(num uint64, wiretype int, v []byte) error {
+		if num != 1 {
+			return nil
+		}
+		return putWrapped(elem, wiretype, v)
+	})
+}
+
+// putWrapped sets val from the value field of a wrapper message.
+func putWrapped(val reflect.Value, wiretype int, v []byte) error {
+	switch val.Kind() {
+	case reflect.String:
+		if wiretype != 2 {
+			return errors.New("bad wiretype for string")
+		}
+		val.SetString(string(v))
+		return nil
+	case reflect.Slice:
+		if wiretype != 2 {
+			return errors.New("bad wiretype for bytes")
+		}
+		val.SetBytes(append([]byte{}, v...))
+		return nil
+	case reflect.Float32:
+		if wiretype != 5 {
+			return errors.New("bad wiretype for float32")
+		}
+		val.SetFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(v))))
+		return nil
+	case reflect.Float64:
+		if wiretype != 1 {
+			return errors.New("bad wiretype for float64")
+		}
+		val.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(v)))
+		return nil
+	}
+
+	if wiretype != 0 {
+		return errors.New("bad wiretype for wrapped " + val.Kind().String())
+	}
+	x, _ := binary.Uvarint(v)
+	switch val.Kind() {
+	case reflect.Bool:
+		if x > 1 {
+			return errors.New("invalid bool value")
+		}
+		val.SetBool(x != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if val.OverflowInt(int64(x)) {
+			return fmt.Errorf("%s value out of range", val.Kind())
+		}
+		val.SetInt(int64(x))
+	default:
+		if val.OverflowUint(x) {
+			return fmt.Errorf("%s value out of range", val.Kind())
+		}
+		val.SetUint(x)
+	}
+	return nil
+}
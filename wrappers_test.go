@@ -0,0 +1,140 @@
+package protobuf
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type wrappedScalars struct {
+	S   *string  `protobuf:"wkt"`
+	I32 *int32   `protobuf:"wkt"`
+	B   *bool    `protobuf:"wkt"`
+	I64 *int64   `protobuf:"wkt"`
+	U32 *uint32  `protobuf:"wkt"`
+	U64 *uint64  `protobuf:"wkt"`
+	F32 *float32 `protobuf:"wkt"`
+	F64 *float64 `protobuf:"wkt"`
+	Bs  *[]byte  `protobuf:"wkt"`
+}
+
+type optionalScalars struct {
+	S *string
+	I *int
+	F *Ufixed32 `protobuf:"fixed"`
+}
+
+func TestWrapperWireFormat(t *testing.T) {
+	s, i32, b := "hi", int32(-1), false
+	in := wrappedScalars{S: &s, I32: &i32, B: &b}
+	buf, err := Encode(&in)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x0a, 4, 0x0a, 2, 'h', 'i',
+		0x12, 11, 0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01,
+		0x1a, 0, // false is omitted from the wrapper
+	}, buf)
+
+	var out wrappedScalars
+	require.NoError(t, Decode(buf, &out))
+	assert.Equal(t, in, out)
+}
+
+func TestWrapperRoundTrip(t *testing.T) {
+	s, i32, b := "", int32(math.MinInt32), true
+	i64, u32, u64 := int64(math.MinInt64), uint32(math.MaxUint32), uint64(math.MaxUint64)
+	f32, f64, bs := float32(-1.5), math.Inf(1), []byte{0, 1}
+	in := wrappedScalars{&s, &i32, &b, &i64, &u32, &u64, &f32, &f64, &bs}
+	buf, err := Encode(&in)
+	require.NoError(t, err)
+	var out wrappedScalars
+	require.NoError(t, Decode(buf, &out))
+	assert.Equal(t, in, out)
+
+	// Nil pointers stay nil.
+	buf, err = Encode(&wrappedScalars{})
+	require.NoError(t, err)
+	assert.Empty(t, buf)
+
+	for _, buf := range [][]byte{
+		{0x12, 6, 0x08, 0x80, 0x80, 0x80, 0x80, 0x08}, // 2^31 in an Int32Value
+		{0x2a, 6, 0x08, 0x80, 0x80, 0x80, 0x80, 0x10}, // 2^32 in a UInt32Value
+		{0x1a, 2, 0x08, 2}, // invalid bool
+		{0x0a, 2, 0x08, 1}, // bad wire type
+	} {
+		out = wrappedScalars{}
+		assert.Error(t, Decode(buf, &out), "%x", buf)
+	}
+}
+
+func TestWrapperOptions(t *testing.T) {
+	s, i, f := "x", -2, Ufixed32(3)
+	in := optionalScalars{&s, &i, &f}
+	buf, err := EncodeWithOptions(&in, EncodeOptions{WellKnownTypes: true})
+	require.NoError(t, err)
+	// The field with an encoding option isn't wrapped.
+	assert.Equal(t, []byte{
+		0x0a, 3, 0x0a, 1, 'x',
+		0x12, 11, 0x08, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01,
+		0x1d, 3, 0, 0, 0,
+	}, buf)
+
+	var out optionalScalars
+	require.NoError(t, DecodeWithOptions(buf, &out, DecodeOptions{WellKnownTypes: true}))
+	assert.Equal(t, in, out)
+
+	// Without the option, the fields are plain.
+	buf, err = Encode(&in)
+	require.NoError(t, err)
+	out = optionalScalars{}
+	require.NoError(t, Decode(buf, &out))
+	assert.Equal(t, in, out)
+
+	assert.Panics(t, func() {
+		ProtoFields(reflect.TypeOf(struct {
+			U *uint32 `protobuf:"fixed,wkt"`
+		}{}))
+	})
+}
+
+func TestGenerateWrappers(t *testing.T) {
+	w := &bytes.Buffer{}
+	opts := GenerateOptions{Proto3: true}
+	require.NoError(t, GenerateWithOptions(w, []interface{}{wrappedScalars{}}, nil, nil, opts))
+	expected := `syntax = "proto3";
+import "google/protobuf/wrappers.proto";
+
+message wrappedScalars {
+  optional google.protobuf.StringValue s = 1;
+  optional google.protobuf.Int32Value i32 = 2;
+  optional google.protobuf.BoolValue b = 3;
+  optional google.protobuf.Int64Value i64 = 4;
+  optional google.protobuf.UInt32Value u32 = 5;
+  optional google.protobuf.UInt64Value u64 = 6;
+  optional google.protobuf.FloatValue f32 = 7;
+  optional google.protobuf.DoubleValue f64 = 8;
+  optional google.protobuf.BytesValue bs = 9;
+}
+
+`
+	assert.Equal(t, expected, w.String())
+
+	w.Reset()
+	opts.WellKnownTypes = true
+	require.NoError(t, GenerateWithOptions(w, []interface{}{optionalScalars{}}, nil, nil, opts))
+	expected = `syntax = "proto3";
+import "google/protobuf/wrappers.proto";
+
+message optionalScalars {
+  optional google.protobuf.StringValue s = 1;
+  optional google.protobuf.Int64Value i = 2;
+  optional fixed32 f = 3;
+}
+
+`
+	assert.Equal(t, expected, w.String())
+}